@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	accessIndexPath = app.Flag(
+		"access.index-path", "The path of the file that records when the cache artefacts were last read, disabled when empty ($TURBO_ACCESS_INDEX_PATH).",
+	).Envar("TURBO_ACCESS_INDEX_PATH").String()
+
+	accessFlushInterval = app.Flag(
+		"access.flush-interval", "How often the recorded access times are written to the file passed via --access.index-path ($TURBO_ACCESS_FLUSH_INTERVAL).",
+	).Envar("TURBO_ACCESS_FLUSH_INTERVAL").Default("10s").Duration()
+)
+
+// accessBucket is the bbolt bucket holding the last access time of the cache
+// artefacts, the keys are the bucket and path of the cache artefacts.
+var accessBucket = []byte("last-access")
+
+// accessIndex records when cache artefacts were last read. Reads only update
+// a map in memory, the map is written to disk in a single transaction every
+// --access.flush-interval, so reads are never slowed down by the disk.
+type accessIndex struct {
+	db *bolt.DB
+
+	mu sync.Mutex
+	// pending are the access times that haven't been written yet, a zero
+	// time removes the cache artefact from the index.
+	pending map[string]time.Time
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// accesses is the index of access times, nil when disabled.
+var accesses *accessIndex
+
+// initAccessIndex opens the index when --access.index-path is given, the
+// returned function writes the pending access times and closes the index.
+func initAccessIndex() (func() error, error) {
+	if *accessIndexPath == "" {
+		return func() error { return nil }, nil
+	}
+
+	db, err := bolt.Open(*accessIndexPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(accessBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	index := &accessIndex{
+		db:      db,
+		pending: make(map[string]time.Time),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go index.run(*accessFlushInterval)
+
+	accesses = index
+	return index.Close, nil
+}
+
+func (a *accessIndex) run(interval time.Duration) {
+	defer close(a.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			if err := a.flush(); err != nil {
+				logger.Log("message", "failed to write the access times of cache items", "error", err)
+			}
+		}
+	}
+}
+
+// record remembers the access time until the next flush.
+func (a *accessIndex) record(key string, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pending[key] = at
+}
+
+// flush writes the pending access times in a single transaction.
+func (a *accessIndex) flush() error {
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = make(map[string]time.Time)
+	a.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(accessBucket)
+		for key, at := range pending {
+			if at.IsZero() {
+				if err := b.Delete([]byte(key)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(at.UnixNano()))
+			if err := b.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns when the cache artefact was last read.
+func (a *accessIndex) Get(key string) (time.Time, bool) {
+	a.mu.Lock()
+	at, ok := a.pending[key]
+	a.mu.Unlock()
+	if ok {
+		return at, !at.IsZero()
+	}
+
+	var value []byte
+	a.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(accessBucket).Get([]byte(key)); v != nil {
+			value = append(value, v...)
+		}
+		return nil
+	})
+	if len(value) != 8 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), true
+}
+
+// Close stops the background writer, writes the pending access times and
+// closes the index.
+func (a *accessIndex) Close() error {
+	close(a.done)
+	<-a.stopped
+
+	if err := a.flush(); err != nil {
+		a.db.Close()
+		return err
+	}
+	return a.db.Close()
+}
+
+// recordAccess records that the cache artefact was read.
+func recordAccess(bucket string, path string, at time.Time) {
+	quotas.Touch(bucket, path, at)
+
+	if accesses != nil {
+		accesses.record(tierKey(bucket, path), at)
+	}
+}
+
+// getLastAccess returns when the cache artefact was last read, as far as the
+// index of access times knows.
+func getLastAccess(bucket string, path string) (time.Time, bool) {
+	if accesses == nil {
+		return time.Time{}, false
+	}
+	return accesses.Get(tierKey(bucket, path))
+}
+
+// forgetArtifact removes a cache artefact that was removed from the storage
+// provider from the indexes.
+func forgetArtifact(bucket string, path string) {
+	quotas.Forget(bucket, path)
+
+	if accesses != nil {
+		accesses.record(tierKey(bucket, path), time.Time{})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupAccessIndex opens the index of access times in a temporary directory,
+// the returned function closes it. The previous values are restored when the
+// test finishes.
+func setupAccessIndex(t *testing.T, path string) func() error {
+	t.Helper()
+
+	previousPath, previousInterval, previousIndex := *accessIndexPath, *accessFlushInterval, accesses
+	t.Cleanup(func() {
+		*accessIndexPath, *accessFlushInterval, accesses = previousPath, previousInterval, previousIndex
+	})
+
+	accesses = nil
+	*accessIndexPath = path
+	// The access times are only written when flushed or closed by the test
+	*accessFlushInterval = time.Hour
+
+	stop, err := initAccessIndex()
+	if err != nil {
+		t.Fatalf("initAccessIndex() failed: %v", err)
+	}
+	return stop
+}
+
+func TestAccessIndex(t *testing.T) {
+	setupTenancy(t, "memory", false, "{{.Hash}}")
+	setupQuota(t, 0, quotaPolicyReject)
+
+	path := filepath.Join(t.TempDir(), "access.db")
+	stop := setupAccessIndex(t, path)
+
+	first := time.Unix(1700000000, 0)
+	second := first.Add(time.Hour)
+
+	recordAccess("bucket", "team_blah/a", first)
+	recordAccess("bucket", "team_blah/b", first)
+
+	// The pending access times are returned before they're written
+	if at, ok := getLastAccess("bucket", "team_blah/a"); !ok || !at.Equal(first) {
+		t.Errorf("getLastAccess() of a pending access = %v, %v, want %v", at, ok, first)
+	}
+
+	if err := accesses.flush(); err != nil {
+		t.Fatalf("flush() failed: %v", err)
+	}
+	recordAccess("bucket", "team_blah/a", second)
+	forgetArtifact("bucket", "team_blah/b")
+
+	tests := []struct {
+		path string
+		at   time.Time
+		ok   bool
+	}{
+		{path: "team_blah/a", at: second, ok: true},
+		{path: "team_blah/b"},
+		{path: "team_blah/c"},
+	}
+
+	check := func(when string) {
+		t.Helper()
+
+		for _, test := range tests {
+			at, ok := getLastAccess("bucket", test.path)
+			if ok != test.ok || !at.Equal(test.at) {
+				t.Errorf("getLastAccess() of %s %s = %v, %v, want %v, %v", test.path, when, at, ok, test.at, test.ok)
+			}
+		}
+	}
+
+	check("before writing")
+	if err := stop(); err != nil {
+		t.Fatalf("closing the index failed: %v", err)
+	}
+
+	// The pending access times are written when the index is closed
+	stop = setupAccessIndex(t, path)
+	defer stop()
+	check("after reopening")
+
+	// Another bucket with the same path is a different cache artefact
+	if _, ok := getLastAccess("other", "team_blah/a"); ok {
+		t.Errorf("getLastAccess() of another bucket found an access time")
+	}
+}
+
+func TestAccessIndexDisabled(t *testing.T) {
+	setupTenancy(t, "memory", false, "{{.Hash}}")
+	setupQuota(t, 0, quotaPolicyReject)
+
+	stop := setupAccessIndex(t, "")
+	defer stop()
+
+	recordAccess("bucket", "team_blah/a", time.Now())
+	if _, ok := getLastAccess("bucket", "team_blah/a"); ok {
+		t.Errorf("getLastAccess() found an access time without an index")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	accountsConfig = app.Flag(
+		"accounts.config", "The path to the JSON file defining the user and teams reported to `turbo login` and `turbo link` ($TURBO_ACCOUNTS_CONFIG).",
+	).Envar("TURBO_ACCOUNTS_CONFIG").String()
+)
+
+// accountUser is the user that is returned by `GET /v2/user`.
+type accountUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Name      string `json:"name,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// accountTeam is a team that is returned by `GET /v2/teams`.
+type accountTeam struct {
+	ID   string `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+	// Role is the role of the user in the team, defaults to OWNER.
+	Role      string `json:"role,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// accounts is the static definition of the user and teams.
+type accounts struct {
+	User  accountUser   `json:"user"`
+	Teams []accountTeam `json:"teams"`
+	// LoginSecret is the secret that needs to be entered on the login page to
+	// receive a token, when empty `turbo login` is disabled.
+	LoginSecret string `json:"loginSecret"`
+	// LoginPermissions are the permissions of the tokens issued by the login
+	// flow, read, write or both, defaults to both. The tokens only have access
+	// to the teams defined here.
+	LoginPermissions []string `json:"loginPermissions"`
+}
+
+// configuredAccounts holds the accounts loaded via --accounts.config.
+var configuredAccounts *accounts
+
+// loadAccounts reads the static definition of the user and teams.
+func loadAccounts(path string) (*accounts, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config accounts
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, err
+	}
+
+	if config.User.ID == "" {
+		return nil, errors.New("the user must have an id")
+	}
+
+	for _, team := range config.Teams {
+		if team.ID == "" || team.Slug == "" {
+			return nil, errors.New("every team must have an id and slug")
+		}
+	}
+
+	for _, permission := range config.LoginPermissions {
+		if permission != permissionRead && permission != permissionWrite {
+			return nil, fmt.Errorf("the login permissions contain the unknown permission '%s'", permission)
+		}
+	}
+
+	return &config, nil
+}
+
+// initAccounts loads the accounts when --accounts.config is given.
+func initAccounts() error {
+	if *accountsConfig == "" {
+		return nil
+	}
+
+	config, err := loadAccounts(*accountsConfig)
+	if err != nil {
+		return err
+	}
+
+	if config.LoginSecret != "" && *loginTokenTTL <= 0 {
+		return errors.New("--login.token-ttl must be positive")
+	}
+
+	configuredAccounts = config
+	return nil
+}
+
+// findTeam looks up the team by its id or slug.
+func (a *accounts) findTeam(idOrSlug string) (accountTeam, bool) {
+	for _, team := range a.Teams {
+		if team.ID == idOrSlug || team.Slug == idOrSlug {
+			return team, true
+		}
+	}
+	return accountTeam{}, false
+}
+
+// teamResponse converts the team into the format turbo expects.
+func teamResponse(team accountTeam) map[string]interface{} {
+	role := team.Role
+	if role == "" {
+		role = "OWNER"
+	}
+
+	return map[string]interface{}{
+		"id":         team.ID,
+		"slug":       team.Slug,
+		"name":       team.Name,
+		"createdAt":  team.CreatedAt,
+		"created":    time.UnixMilli(team.CreatedAt).UTC().Format(time.RFC3339),
+		"membership": map[string]string{"role": role},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	response, err := json.Marshal(value)
+	if err != nil {
+		logger.Log("message", "failed to encode the response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+func writeAccountsNotConfigured(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"error":{"message":"no user and teams are configured on the server","code":"not_found"}}`))
+}
+
+// readUser returns the configured user, used by `turbo login`.
+func readUser(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "readUser()")
+
+	if configuredAccounts == nil {
+		writeAccountsNotConfigured(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"user": configuredAccounts.User})
+}
+
+// readTeams returns the configured teams, used by `turbo link`.
+func readTeams(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "readTeams()")
+
+	if configuredAccounts == nil {
+		writeAccountsNotConfigured(w)
+		return
+	}
+
+	// Only the teams the token has access to are listed
+	who := getPrincipal(r.Context())
+
+	teams := make([]map[string]interface{}, 0, len(configuredAccounts.Teams))
+	for _, team := range configuredAccounts.Teams {
+		if who != nil && !who.allowsTeam(team.ID) {
+			continue
+		}
+		teams = append(teams, teamResponse(team))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"teams": teams,
+		"pagination": map[string]interface{}{
+			"count": len(teams),
+			"next":  nil,
+			"prev":  nil,
+		},
+	})
+}
+
+// readTeam returns a single team by its id or slug.
+func readTeam(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "readTeam()")
+
+	if configuredAccounts == nil {
+		writeAccountsNotConfigured(w)
+		return
+	}
+
+	team, ok := configuredAccounts.findTeam(mux.Vars(r)["teamId"])
+	if who := getPrincipal(r.Context()); ok && who != nil && !who.allowsTeam(team.ID) {
+		ok = false
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"message":"Team not found","code":"not_found"}}`))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, teamResponse(team))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	permissionRead  = "read"
+	permissionWrite = "write"
+)
+
+// principal is who made a request, as determined by the authentication.
+type principal struct {
+	// Name identifies the principal in the logs, it's never the token.
+	Name string
+	// ID identifies the token of the principal without containing it, as the
+	// tokens passed via --turbo-token and the login tokens share their name.
+	// It's empty when no token was used.
+	ID string
+	// Teams are the ids or slugs of the teams the principal has access to,
+	// nil gives access to all teams.
+	Teams []string
+	Read  bool
+	Write bool
+}
+
+// fullAccess returns a principal with access to all teams, used for the
+// tokens passed via --turbo-token and client certificates.
+func fullAccess(name string) *principal {
+	return &principal{Name: name, Read: true, Write: true}
+}
+
+// withID returns a copy of the principal identified by the token, the
+// principals of the token file are shared by all requests.
+func (p *principal) withID(token string) *principal {
+	identified := *p
+	identified.ID = tokenID(token)
+	return &identified
+}
+
+// allowsTeam returns whether the principal has access to the team, the team
+// can be referred to by id or slug when the teams are known via
+// --accounts.config.
+func (p *principal) allowsTeam(teamID string) bool {
+	if p.Teams == nil {
+		return true
+	}
+
+	for _, allowed := range p.Teams {
+		if allowed == "*" || allowed == teamID {
+			return true
+		}
+	}
+
+	if configuredAccounts == nil {
+		return false
+	}
+
+	team, ok := configuredAccounts.findTeam(teamID)
+	if !ok {
+		return false
+	}
+	for _, allowed := range p.Teams {
+		if allowed == team.ID || allowed == team.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *principal) hasPermission(permission string) bool {
+	switch permission {
+	case permissionRead:
+		return p.Read
+	case permissionWrite:
+		return p.Write
+	}
+	return false
+}
+
+// requiredPermission returns the permission needed for the request, only
+// uploading a cache artefact requires write access. Querying cache artefacts
+// and recording the usage events are part of reading from the cache.
+func requiredPermission(req *http.Request) string {
+	if _, ok := mux.Vars(req)["artificateId"]; ok && (req.Method == http.MethodPut || req.Method == http.MethodPost) {
+		return permissionWrite
+	}
+	return permissionRead
+}
+
+// isAuthorized returns whether the principal may make the request, requests
+// for a team need access to that team.
+func isAuthorized(req *http.Request, who *principal) bool {
+	if !who.hasPermission(requiredPermission(req)) {
+		return false
+	}
+
+	query := req.URL.Query()
+	if !query.Has("teamId") && !query.Has("slug") {
+		return true
+	}
+
+	// Both are checked, as the handlers prefer the slug over the team id
+	for _, key := range []string{"teamId", "slug"} {
+		if query.Has(key) && !who.allowsTeam(query.Get(key)) {
+			return false
+		}
+	}
+	return true
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, who *principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, who)
+}
+
+// getPrincipal returns who made the request, or nil when the request isn't
+// authenticated.
+func getPrincipal(ctx context.Context) *principal {
+	who, _ := ctx.Value(principalKey{}).(*principal)
+	return who
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	authFailureRate = app.Flag(
+		"auth.failure-rate", "The number of failed authentications per second accepted from a client IP address, 0 disables the limit ($TURBO_AUTH_FAILURE_RATE).",
+	).Envar("TURBO_AUTH_FAILURE_RATE").Default("0").Float64()
+
+	authFailureBurst = app.Flag(
+		"auth.failure-burst", "The number of failed authentications accepted from a client IP address at once ($TURBO_AUTH_FAILURE_BURST).",
+	).Envar("TURBO_AUTH_FAILURE_BURST").Default("10").Int()
+
+	authClientIPHeader = app.Flag(
+		"auth.client-ip-header", "The header a trusted proxy puts the IP address of the client in, e.g. X-Forwarded-For, the last address of the header is used ($TURBO_AUTH_CLIENT_IP_HEADER).",
+	).Envar("TURBO_AUTH_CLIENT_IP_HEADER").String()
+)
+
+// authFailureIdleTimeout is how long the failed authentications of a client
+// are remembered after its last attempt.
+const authFailureIdleTimeout = 10 * time.Minute
+
+// authFailureLimiter is the token bucket of the failed authentications of a
+// client IP address.
+type authFailureLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+// authFailures holds the limiters of the clients that tried to authenticate
+// recently.
+var authFailures = struct {
+	sync.Mutex
+	limiters map[string]*authFailureLimiter
+}{limiters: make(map[string]*authFailureLimiter)}
+
+func isAuthFailureLimitEnabled() bool {
+	return *authFailureRate > 0
+}
+
+// clientIP returns the IP address of the client, when the server runs behind
+// a proxy the address is taken from the header set by the proxy. Only the
+// last address can be trusted, the ones before it are sent by the client.
+func clientIP(req *http.Request) string {
+	if *authClientIPHeader != "" {
+		if values := req.Header.Values(*authClientIPHeader); len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// getAuthFailureLimiter returns the limiter of the client, creating it on the
+// first attempt.
+func getAuthFailureLimiter(ip string, now time.Time) *authFailureLimiter {
+	authFailures.Lock()
+	defer authFailures.Unlock()
+
+	limiter, ok := authFailures.limiters[ip]
+	if !ok {
+		limiter = &authFailureLimiter{Limiter: rate.NewLimiter(rate.Limit(*authFailureRate), *authFailureBurst)}
+		authFailures.limiters[ip] = limiter
+	}
+	limiter.lastUsed = now
+	return limiter
+}
+
+// authFailureDelay returns how long the client has to wait before it may try
+// to authenticate again, or 0 when it didn't fail too often.
+func authFailureDelay(req *http.Request) time.Duration {
+	if !isAuthFailureLimitEnabled() {
+		return 0
+	}
+
+	// Reserving no attempt checks whether the failures of the client
+	// exceeded the burst, without taking from the bucket
+	now := time.Now()
+	delay := getAuthFailureLimiter(clientIP(req), now).ReserveN(now, 0).DelayFrom(now)
+	if delay > 0 {
+		rejectedAuthentications.Inc()
+		logger.Log("message", "too many failed authentications", "clientIP", clientIP(req), "retryAfter", delay)
+	}
+	return delay
+}
+
+// recordAuthFailure takes an attempt from the bucket of the client, after the
+// token or the login secret it sent wasn't accepted.
+func recordAuthFailure(req *http.Request) {
+	if !isAuthFailureLimitEnabled() {
+		return
+	}
+
+	now := time.Now()
+	getAuthFailureLimiter(clientIP(req), now).ReserveN(now, 1)
+}
+
+// removeIdleAuthFailures forgets the clients that didn't try to authenticate
+// recently.
+func removeIdleAuthFailures(now time.Time) {
+	authFailures.Lock()
+	defer authFailures.Unlock()
+
+	for ip, limiter := range authFailures.limiters {
+		if now.Sub(limiter.lastUsed) > authFailureIdleTimeout {
+			delete(authFailures.limiters, ip)
+		}
+	}
+}
+
+// initAuthFailures validates the limit, the returned function stops removing
+// the idle limiters.
+func initAuthFailures() (func(), error) {
+	if !isAuthFailureLimitEnabled() {
+		return func() {}, nil
+	}
+
+	if *authFailureBurst < 1 {
+		return nil, errors.New("--auth.failure-burst must be at least 1")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				removeIdleAuthFailures(now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}, nil
+}
+
+// retryAfterSeconds sets the Retry-After header, the delay is rounded up to
+// whole seconds.
+func retryAfterSeconds(w http.ResponseWriter, delay time.Duration) string {
+	seconds := strconv.Itoa(int(math.Ceil(delay.Seconds())))
+	w.Header().Set("Retry-After", seconds)
+	return seconds
+}
+
+// writeTooManyAuthFailures rejects the request of a client that failed to
+// authenticate too often.
+func writeTooManyAuthFailures(w http.ResponseWriter, delay time.Duration) {
+	seconds := retryAfterSeconds(w, delay)
+	writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error": map[string]string{
+			"message": "too many failed authentications, retry after " + seconds + " seconds",
+			"code":    "rate_limited",
+		},
+	})
+}
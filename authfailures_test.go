@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+// setupAuthFailures enables the limit of failed authentications for a test,
+// the previous values are restored when the test finishes.
+func setupAuthFailures(t *testing.T, perSecond float64, burst int, header string) {
+	t.Helper()
+
+	previousLogger := logger
+	previousRate, previousBurst, previousHeader := *authFailureRate, *authFailureBurst, *authClientIPHeader
+	t.Cleanup(func() {
+		logger = previousLogger
+		*authFailureRate, *authFailureBurst, *authClientIPHeader = previousRate, previousBurst, previousHeader
+
+		authFailures.Lock()
+		authFailures.limiters = make(map[string]*authFailureLimiter)
+		authFailures.Unlock()
+	})
+
+	logger = log.NewNopLogger()
+	*authFailureRate, *authFailureBurst, *authClientIPHeader = perSecond, burst, header
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		header     string
+		remoteAddr string
+		values     []string
+		want       string
+	}{
+		{"", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"", "10.0.0.1:1234", []string{"192.0.2.1"}, "10.0.0.1"},
+		{"X-Forwarded-For", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"X-Forwarded-For", "10.0.0.1:1234", []string{"192.0.2.1"}, "192.0.2.1"},
+		{"X-Forwarded-For", "10.0.0.1:1234", []string{"198.51.100.7, 192.0.2.1"}, "192.0.2.1"},
+		{"X-Forwarded-For", "10.0.0.1:1234", []string{"198.51.100.7", "192.0.2.1"}, "192.0.2.1"},
+		{"X-Forwarded-For", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		setupAuthFailures(t, 1, 1, test.header)
+
+		req := httptest.NewRequest("GET", "/v8/artifacts/abc", nil)
+		req.RemoteAddr = test.remoteAddr
+		for _, value := range test.values {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+
+		if ip := clientIP(req); ip != test.want {
+			t.Errorf("clientIP() with header %q and values %q returned %q, want %q", test.header, test.values, ip, test.want)
+		}
+	}
+}
+
+func TestAuthFailureLimit(t *testing.T) {
+	setupAuthFailures(t, 0.001, 3, "X-Forwarded-For")
+
+	client := httptest.NewRequest("GET", "/v8/artifacts/abc", nil)
+	client.Header.Set("X-Forwarded-For", "192.0.2.1")
+	other := httptest.NewRequest("GET", "/v8/artifacts/abc", nil)
+	other.Header.Set("X-Forwarded-For", "192.0.2.2")
+
+	// The client is only rejected after its failures exceeded the burst
+	for i := 0; i < 3; i++ {
+		if delay := authFailureDelay(client); delay > 0 {
+			t.Fatalf("the client was rejected after %d failures, want 3 failures to be accepted", i)
+		}
+		recordAuthFailure(client)
+	}
+	recordAuthFailure(client)
+
+	if delay := authFailureDelay(client); delay <= 0 {
+		t.Errorf("the client wasn't rejected after exceeding the burst")
+	}
+	if delay := authFailureDelay(other); delay > 0 {
+		t.Errorf("another client was rejected, got delay %v", delay)
+	}
+}
+
+func TestAuthFailureLimitDisabled(t *testing.T) {
+	setupAuthFailures(t, 0, 1, "")
+
+	req := httptest.NewRequest("GET", "/v8/artifacts/abc", nil)
+	for i := 0; i < 10; i++ {
+		recordAuthFailure(req)
+	}
+
+	if delay := authFailureDelay(req); delay > 0 {
+		t.Errorf("got delay %v with the limit disabled, want 0", delay)
+	}
+}
@@ -0,0 +1,118 @@
+package azure
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	az "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/graymeta/stow"
+)
+
+// Kind represents the name of the location/storage type.
+const Kind = "azure"
+
+const (
+	// ConfigAccount is the name of the storage account.
+	ConfigAccount = "account"
+
+	// ConfigKey is the shared key of the storage account.
+	ConfigKey = "key"
+
+	// ConfigSASToken is a shared access signature that is used instead of the
+	// shared key of the storage account.
+	ConfigSASToken = "sas_token"
+
+	// ConfigConnectionString is the connection string of the storage account,
+	// when given the other configuration is ignored.
+	ConfigConnectionString = "connection_string"
+
+	// ConfigBaseURL is the domain of the storage service, defaults to
+	// `core.windows.net`.
+	ConfigBaseURL = "base_url"
+)
+
+// developmentStorageConnectionString is the connection string used to connect
+// to the storage emulator (Azurite).
+const developmentStorageConnectionString = "usedevelopmentstorage=true"
+
+func init() {
+	validatefn := func(config stow.Config) error {
+		if connectionString, ok := config.Config(ConfigConnectionString); ok && connectionString != "" {
+			return nil
+		}
+
+		account, ok := config.Config(ConfigAccount)
+		if !ok || account == "" {
+			return errors.New("missing account name")
+		}
+
+		key, _ := config.Config(ConfigKey)
+		sasToken, _ := config.Config(ConfigSASToken)
+		if key == "" && sasToken == "" && account != az.StorageEmulatorAccountName {
+			return errors.New("missing account key or SAS token")
+		}
+		return nil
+	}
+	makefn := func(config stow.Config) (stow.Location, error) {
+		if err := validatefn(config); err != nil {
+			return nil, err
+		}
+
+		client, err := newBlobStorageClient(config)
+		if err != nil {
+			return nil, err
+		}
+
+		loc := &location{
+			config: config,
+			client: client,
+		}
+		return loc, nil
+	}
+
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == Kind
+	}
+
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
+
+// newBlobStorageClient creates the client based on the connection string,
+// the SAS token or the shared key, in that order.
+func newBlobStorageClient(config stow.Config) (*az.BlobStorageClient, error) {
+	client, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	blobService := client.GetBlobService()
+	return &blobService, nil
+}
+
+func newClient(config stow.Config) (az.Client, error) {
+	if connectionString, ok := config.Config(ConfigConnectionString); ok && connectionString != "" {
+		if strings.ToLower(strings.TrimSpace(connectionString)) == developmentStorageConnectionString {
+			return az.NewEmulatorClient()
+		}
+		return az.NewClientFromConnectionString(connectionString)
+	}
+
+	account, _ := config.Config(ConfigAccount)
+	if account == az.StorageEmulatorAccountName {
+		return az.NewEmulatorClient()
+	}
+
+	baseURL := az.DefaultBaseURL
+	if s, ok := config.Config(ConfigBaseURL); ok && s != "" {
+		baseURL = s
+	}
+
+	if sasToken, ok := config.Config(ConfigSASToken); ok && sasToken != "" {
+		endpoint := "https://" + account + ".blob." + baseURL
+		return az.NewAccountSASClientFromEndpointToken(endpoint, strings.TrimPrefix(sasToken, "?"))
+	}
+
+	key, _ := config.Config(ConfigKey)
+	return az.NewClient(account, key, baseURL, az.DefaultAPIVersion, true)
+}
@@ -0,0 +1,201 @@
+package azure
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	az "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// The maximum size of an item that is Put in a single request, larger items
+// and items of unknown size are uploaded in blocks.
+const maxPutSize = startChunkSize
+
+type container struct {
+	id         string
+	properties az.ContainerProperties
+	client     *az.BlobStorageClient
+}
+
+var _ stow.Container = (*container)(nil)
+
+func (c *container) ID() string {
+	return c.id
+}
+
+func (c *container) Name() string {
+	return c.id
+}
+
+func (c *container) Item(id string) (stow.Item, error) {
+	blob := c.client.GetContainerReference(c.id).GetBlobReference(id)
+	err := blob.GetProperties(nil)
+	if err != nil {
+		if hasStatusCode(err, http.StatusNotFound) {
+			return nil, stow.ErrNotFound
+		}
+		return nil, err
+	}
+
+	// Etags returned from this method include quotes. Strip them.
+	blob.Properties.Etag = cleanEtag(blob.Properties.Etag)
+
+	// The metadata is returned together with the properties
+	return &item{
+		id:         id,
+		container:  c,
+		client:     c.client,
+		properties: blob.Properties,
+		metadata:   parseMetadata(blob.Metadata),
+	}, nil
+}
+
+func (c *container) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	params := az.ListBlobsParameters{
+		Prefix:     prefix,
+		MaxResults: uint(count),
+		Include:    &az.IncludeBlobDataset{Metadata: true},
+	}
+	if cursor != stow.CursorStart {
+		params.Marker = cursor
+	}
+	listblobs, err := c.client.GetContainerReference(c.id).ListBlobs(params)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]stow.Item, len(listblobs.Blobs))
+	for i, blob := range listblobs.Blobs {
+
+		// Clean Etag just in case.
+		blob.Properties.Etag = cleanEtag(blob.Properties.Etag)
+
+		items[i] = &item{
+			id:         blob.Name,
+			container:  c,
+			client:     c.client,
+			properties: blob.Properties,
+			metadata:   parseMetadata(blob.Metadata),
+		}
+	}
+	return items, listblobs.NextMarker, nil
+}
+
+// Put stores the item together with its metadata in a single request, so the
+// item is never visible without its metadata. A size of -1 means the size is
+// unknown, the item is then uploaded in blocks until the reader is drained.
+func (c *container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	mdParsed, err := prepMetadata(metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create or update Item, preparing metadata")
+	}
+
+	blob := c.client.GetContainerReference(c.id).GetBlobReference(name)
+	blob.Metadata = mdParsed
+
+	if size >= 0 && size <= maxPutSize {
+		// The SDK buffers small items in memory to determine their length
+		err = blob.CreateBlockBlobFromReader(io.LimitReader(r, size), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create or update Item")
+		}
+	} else {
+		size, err = c.multipartUpload(blob, r, size)
+		if err != nil {
+			return nil, errors.Wrap(err, "multipart upload")
+		}
+	}
+
+	item := &item{
+		id:        name,
+		container: c,
+		client:    c.client,
+		properties: az.BlobProperties{
+			LastModified:  az.TimeRFC1123(time.Now()),
+			Etag:          "",
+			ContentLength: size,
+		},
+		metadata: parseMetadata(mdParsed),
+	}
+	return item, nil
+}
+
+func (c *container) RemoveItem(id string) error {
+	err := c.client.GetContainerReference(c.id).GetBlobReference(id).Delete(nil)
+	if err != nil && hasStatusCode(err, http.StatusNotFound) {
+		return stow.ErrNotFound
+	}
+	return err
+}
+
+// Metadata names need to be valid C# identifiers, the dashes used by the
+// other storage providers are stored as underscores. Azure doesn't preserve
+// the case of the names, so the names are always stored in lowercase.
+func metadataName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
+func metadataKey(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+func parseMetadata(md map[string]string) map[string]interface{} {
+	rtnMap := make(map[string]interface{}, len(md))
+	for name, value := range md {
+		rtnMap[metadataKey(name)] = value
+	}
+	return rtnMap
+}
+
+func prepMetadata(md map[string]interface{}) (map[string]string, error) {
+	rtnMap := make(map[string]string, len(md))
+	for key, value := range md {
+		str, ok := value.(string)
+		if !ok {
+			return nil, errors.Errorf(`value of key '%s' in metadata must be of type string`, key)
+		}
+		rtnMap[metadataName(key)] = str
+	}
+	return rtnMap, nil
+}
+
+// Remove quotation marks from beginning and end. This includes quotations that
+// are escaped. Also removes leading `W/` from prefix for weak Etags.
+//
+// Based on the Etag spec, the full etag value (<FULL ETAG VALUE>) can include:
+// - W/"<ETAG VALUE>"
+// - "<ETAG VALUE>"
+// - ""
+// Source: https://tools.ietf.org/html/rfc7232#section-2.3
+//
+// Based on HTTP spec, forward slash is a separator and must be enclosed in
+// quotes to be used as a valid value. Hence, the returned value may include:
+// - "<FULL ETAG VALUE>"
+// - \"<FULL ETAG VALUE>\"
+// Source: https://www.w3.org/Protocols/rfc2616/rfc2616-sec2.html#sec2.2
+//
+// This function contains a loop to check for the presence of the three possible
+// filler characters and strips them, resulting in only the Etag value.
+func cleanEtag(etag string) string {
+	for {
+		// Check if the filler characters are present
+		if strings.HasPrefix(etag, `\"`) {
+			etag = strings.Trim(etag, `\"`)
+
+		} else if strings.HasPrefix(etag, `"`) {
+			etag = strings.Trim(etag, `"`)
+
+		} else if strings.HasPrefix(etag, `W/`) {
+			etag = strings.Replace(etag, `W/`, "", 1)
+
+		} else {
+
+			break
+		}
+	}
+
+	return etag
+}
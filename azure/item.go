@@ -0,0 +1,71 @@
+package azure
+
+import (
+	"io"
+	"net/url"
+	"time"
+
+	az "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/graymeta/stow"
+)
+
+type item struct {
+	id         string
+	container  *container
+	client     *az.BlobStorageClient
+	properties az.BlobProperties
+	metadata   map[string]interface{}
+}
+
+var (
+	_ stow.Item       = (*item)(nil)
+	_ stow.ItemRanger = (*item)(nil)
+)
+
+func (i *item) ID() string {
+	return i.id
+}
+
+func (i *item) Name() string {
+	return i.id
+}
+
+func (i *item) URL() *url.URL {
+	u := i.client.GetContainerReference(i.container.id).GetBlobReference(i.id).GetURL()
+	url, _ := url.Parse(u)
+	url.Scheme = Kind
+	return url
+}
+
+func (i *item) Size() (int64, error) {
+	return i.properties.ContentLength, nil
+}
+
+func (i *item) Open() (io.ReadCloser, error) {
+	return i.client.GetContainerReference(i.container.id).GetBlobReference(i.id).Get(nil)
+}
+
+func (i *item) ETag() (string, error) {
+	return i.properties.Etag, nil
+}
+
+func (i *item) LastMod() (time.Time, error) {
+	return time.Time(i.properties.LastModified), nil
+}
+
+// Metadata returns the metadata that was retrieved together with the item.
+func (i *item) Metadata() (map[string]interface{}, error) {
+	return i.metadata, nil
+}
+
+// OpenRange opens the item for reading starting at byte start and ending
+// at byte end.
+func (i *item) OpenRange(start, end uint64) (io.ReadCloser, error) {
+	opts := &az.GetBlobRangeOptions{
+		Range: &az.BlobRange{
+			Start: start,
+			End:   end,
+		},
+	}
+	return i.client.GetContainerReference(i.container.id).GetBlobReference(i.id).GetRange(opts)
+}
@@ -0,0 +1,127 @@
+package azure
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	az "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/graymeta/stow"
+)
+
+type location struct {
+	config stow.Config
+	client *az.BlobStorageClient
+}
+
+func (l *location) Close() error {
+	return nil // nothing to close
+}
+
+// CreateContainer creates a private container, the cache artefacts should
+// never be readable without going through the server.
+func (l *location) CreateContainer(name string) (stow.Container, error) {
+	ref := l.client.GetContainerReference(name)
+	err := ref.Create(&az.CreateContainerOptions{Access: az.ContainerAccessTypePrivate})
+	if err != nil {
+		// Another instance might have created the container in the meantime
+		if hasStatusCode(err, http.StatusConflict) {
+			return l.Container(name)
+		}
+		return nil, err
+	}
+
+	if err := ref.GetProperties(); err != nil {
+		return nil, err
+	}
+
+	return &container{
+		id:         name,
+		properties: ref.Properties,
+		client:     l.client,
+	}, nil
+}
+
+func (l *location) Containers(prefix, cursor string, count int) ([]stow.Container, string, error) {
+	params := az.ListContainersParameters{
+		MaxResults: uint(count),
+		Prefix:     prefix,
+	}
+	if cursor != stow.CursorStart {
+		params.Marker = cursor
+	}
+	response, err := l.client.ListContainers(params)
+	if err != nil {
+		return nil, "", err
+	}
+	containers := make([]stow.Container, len(response.Containers))
+	for i, azureContainer := range response.Containers {
+		containers[i] = &container{
+			id:         azureContainer.Name,
+			properties: azureContainer.Properties,
+			client:     l.client,
+		}
+	}
+	return containers, response.NextMarker, nil
+}
+
+// Container looks up the container directly instead of listing all the
+// containers of the storage account.
+func (l *location) Container(id string) (stow.Container, error) {
+	ref := l.client.GetContainerReference(id)
+	if err := ref.GetProperties(); err != nil {
+		if hasStatusCode(err, http.StatusNotFound) {
+			return nil, stow.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &container{
+		id:         id,
+		properties: ref.Properties,
+		client:     l.client,
+	}, nil
+}
+
+func (l *location) ItemByURL(url *url.URL) (stow.Item, error) {
+	if url.Scheme != Kind {
+		return nil, errors.New("not valid azure URL")
+	}
+
+	// The account is unknown when a connection string is used
+	if a, ok := l.config.Config(ConfigAccount); ok && a != "" && a != strings.Split(url.Host, ".")[0] {
+		return nil, errors.New("wrong azure URL")
+	}
+
+	path := strings.TrimLeft(url.Path, "/")
+	params := strings.SplitN(path, "/", 2)
+	if len(params) != 2 {
+		return nil, errors.New("wrong path")
+	}
+	c, err := l.Container(params[0])
+	if err != nil {
+		return nil, err
+	}
+	return c.Item(params[1])
+}
+
+func (l *location) RemoveContainer(id string) error {
+	return l.client.GetContainerReference(id).Delete(nil)
+}
+
+// hasStatusCode checks whether the storage service responded with the given
+// status code.
+func hasStatusCode(err error, statusCode int) bool {
+	var serviceErr az.AzureStorageServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.StatusCode == statusCode
+	}
+
+	var statusErr az.UnexpectedStatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.Got() == statusCode
+	}
+
+	return false
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	eventsFile = app.Flag(
+		"events.file", "The path of the file to append received cache usage events to as JSON lines ($TURBO_EVENTS_FILE).",
+	).Envar("TURBO_EVENTS_FILE").String()
+
+	eventsMaxSize = app.Flag(
+		"events.max-size", "The size at which the file passed via --events.file is rotated, the previous file is kept with the .1 suffix, 0 disables the rotation ($TURBO_EVENTS_MAX_SIZE).",
+	).Envar("TURBO_EVENTS_MAX_SIZE").Default("100MB").Bytes()
+)
+
+// maxCacheEventsBodySize is the maximum size of the cache usage events turbo
+// sends at once, turbo sends the events of a single run.
+const maxCacheEventsBodySize = 1024 * 1024
+
+const (
+	cacheEventHit     = "HIT"
+	cacheEventMiss    = "MISS"
+	cacheSourceLocal  = "LOCAL"
+	cacheSourceRemote = "REMOTE"
+)
+
+// cacheEvent is a single cache usage event as sent by turbo to
+// `POST /v8/artifacts/events`.
+type cacheEvent struct {
+	SessionID string `json:"sessionId"`
+	Source    string `json:"source"`
+	Event     string `json:"event"`
+	Hash      string `json:"hash"`
+	Duration  int64  `json:"duration,omitempty"`
+}
+
+// cacheEventStats are the aggregated cache usage events of a team.
+type cacheEventStats struct {
+	TeamID      string  `json:"teamId"`
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	LocalHits   int64   `json:"localHits"`
+	RemoteHits  int64   `json:"remoteHits"`
+	HitRate     float64 `json:"hitRate"`
+	TimeSavedMs int64   `json:"timeSavedMs"`
+}
+
+// EventSink records the cache usage events received for a team.
+type EventSink interface {
+	Record(teamID string, events []cacheEvent) error
+}
+
+// multiEventSink forwards the events to all of its sinks.
+type multiEventSink []EventSink
+
+func (s multiEventSink) Record(teamID string, events []cacheEvent) error {
+	for _, sink := range s {
+		if err := sink.Record(teamID, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryEventSink keeps counters of the received events per team.
+type memoryEventSink struct {
+	mu    sync.Mutex
+	teams map[string]*cacheEventStats
+}
+
+func newMemoryEventSink() *memoryEventSink {
+	return &memoryEventSink{teams: make(map[string]*cacheEventStats)}
+}
+
+func (s *memoryEventSink) Record(teamID string, events []cacheEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.teams[teamID]
+	if !ok {
+		stats = &cacheEventStats{TeamID: teamID}
+		s.teams[teamID] = stats
+	}
+
+	for _, event := range events {
+		switch event.Event {
+		case cacheEventHit:
+			stats.Hits++
+			stats.TimeSavedMs += event.Duration
+			if event.Source == cacheSourceLocal {
+				stats.LocalHits++
+			} else {
+				stats.RemoteHits++
+			}
+		case cacheEventMiss:
+			stats.Misses++
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the aggregated events of the given team.
+func (s *memoryEventSink) Stats(teamID string) cacheEventStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := cacheEventStats{TeamID: teamID}
+	if teamStats, ok := s.teams[teamID]; ok {
+		stats = *teamStats
+	}
+
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+
+	return stats
+}
+
+// fileEventSink appends every received event as a JSON line to a file. When
+// the file grows beyond maxSize it's rotated, so replaying the events at
+// startup takes a bounded amount of time.
+type fileEventSink struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// fileEventRecord is a line in the file of the fileEventSink.
+type fileEventRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	TeamID    string    `json:"teamId"`
+	cacheEvent
+}
+
+func newFileEventSink(path string, maxSize int64) (*fileEventSink, error) {
+	sink := &fileEventSink{path: path, maxSize: maxSize}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// rotatedEventFile returns the path the previous events are kept at.
+func rotatedEventFile(path string) string {
+	return path + ".1"
+}
+
+// open opens the file for appending, the caller must hold the lock.
+func (s *fileEventSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate replaces the previously rotated file with the current file and
+// starts a new file, the caller must hold the lock.
+func (s *fileEventSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path, rotatedEventFile(s.path)); err != nil {
+		return err
+	}
+
+	logger.Log("message", "rotated the cache usage events file", "path", s.path, "size", s.size)
+	return s.open()
+}
+
+func (s *fileEventSink) Record(teamID string, events []cacheEvent) error {
+	now := time.Now().UTC()
+
+	var lines []byte
+	for _, event := range events {
+		line, err := json.Marshal(fileEventRecord{Timestamp: now, TeamID: teamID, cacheEvent: event})
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line...)
+		lines = append(lines, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(lines)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(lines)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the underlying file.
+func (s *fileEventSink) Close() error {
+	return s.file.Close()
+}
+
+// replayEventFile records the events of an earlier run in the sink, so the
+// aggregated stats aren't reset when the server restarts. Lines that can't be
+// decoded, e.g. a line that was cut off by a crash, are skipped.
+func replayEventFile(path string, sink *memoryEventSink) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	replayed, skipped := 0, 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record fileEventRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.TeamID == "" {
+			skipped++
+			continue
+		}
+
+		sink.Record(record.TeamID, []cacheEvent{record.cacheEvent})
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	logger.Log("message", "replayed the cache usage events", "path", path, "events", replayed, "skipped", skipped)
+	return nil
+}
+
+var (
+	eventStats           = newMemoryEventSink()
+	eventSink  EventSink = eventStats
+)
+
+// initEventSink sets up the sinks that should receive the cache usage events.
+func initEventSink() (func() error, error) {
+	if *eventsFile == "" {
+		return func() error { return nil }, nil
+	}
+
+	// The rotated events are older, so they're replayed first
+	for _, path := range []string{rotatedEventFile(*eventsFile), *eventsFile} {
+		if err := replayEventFile(path, eventStats); err != nil {
+			return nil, fmt.Errorf("failed to replay the cache usage events: %w", err)
+		}
+	}
+
+	fileSink, err := newFileEventSink(*eventsFile, int64(*eventsMaxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	eventSink = multiEventSink{eventStats, fileSink}
+	return fileSink.Close, nil
+}
+
+// recordCacheEvents accepts the cache usage events turbo sends after a run.
+func recordCacheEvents(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "recordCacheEvents()")
+
+	query := r.URL.Query()
+	if !query.Has("teamId") && !query.Has("slug") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(`{"error":{"message":"teamID or slug is missing","code":"required"}}`))
+		return
+	}
+
+	var events []cacheEvent
+	body := http.MaxBytesReader(w, r.Body, maxCacheEventsBodySize)
+	if err := json.NewDecoder(body).Decode(&events); err != nil {
+		logger.Log("message", "failed to decode the cache events", "error", err)
+
+		// The error of http.MaxBytesReader has no type before Go 1.19
+		if err.Error() == "http: request body too large" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(`{"error":{"message":"the request body exceeds the maximum size","code":"payload_too_large"}}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"invalid request body","code":"bad_request"}}`))
+		return
+	}
+
+	for _, event := range events {
+		validEvent := event.Event == cacheEventHit || event.Event == cacheEventMiss
+		validSource := event.Source == cacheSourceLocal || event.Source == cacheSourceRemote
+		if !validEvent || !validSource {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"event must be HIT or MISS and source must be LOCAL or REMOTE","code":"bad_request"}}`))
+			return
+		}
+	}
+
+	teamID := getTeamID(query)
+	if err := eventSink.Record(teamID, events); err != nil {
+		logger.Log("message", "failed to record cache events", "teamID", teamID, "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"message":"failed to record cache events","code":"internal_error"}}`))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// readCacheEvents returns the aggregated hit rate and time saved of a team.
+func readCacheEvents(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "readCacheEvents()")
+
+	query := r.URL.Query()
+	if !query.Has("teamId") && !query.Has("slug") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(`{"error":{"message":"teamID or slug is missing","code":"required"}}`))
+		return
+	}
+
+	response, err := json.Marshal(eventStats.Stats(getTeamID(query)))
+	if err != nil {
+		logger.Log("message", "failed to encode the cache event stats", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/units"
+	"github.com/go-kit/log"
+)
+
+// setupEvents configures where the cache usage events are recorded for a
+// test, the previous values are restored when the test finishes.
+func setupEvents(t *testing.T, path string, maxSize int64) {
+	t.Helper()
+
+	previousLogger, previousStats, previousSink := logger, eventStats, eventSink
+	previousFile, previousMaxSize := *eventsFile, *eventsMaxSize
+	t.Cleanup(func() {
+		logger, eventStats, eventSink = previousLogger, previousStats, previousSink
+		*eventsFile, *eventsMaxSize = previousFile, previousMaxSize
+	})
+
+	logger = log.NewNopLogger()
+	eventStats = newMemoryEventSink()
+	eventSink = eventStats
+	*eventsFile = path
+	*eventsMaxSize = units.Base2Bytes(maxSize)
+}
+
+func TestRecordCacheEventsBodyLimit(t *testing.T) {
+	setupEvents(t, "", 0)
+
+	event := `{"sessionId":"abc","source":"REMOTE","event":"HIT","hash":"abc","duration":100}`
+	tests := []struct {
+		name   string
+		events int
+		code   int
+	}{
+		{name: "a run", events: 100, code: http.StatusOK},
+		{name: "too large", events: maxCacheEventsBodySize / len(event), code: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := "[" + strings.TrimSuffix(strings.Repeat(event+",", test.events), ",") + "]"
+			req := httptest.NewRequest(http.MethodPost, "/v8/artifacts/events?teamId=team_blah", strings.NewReader(body))
+			res := httptest.NewRecorder()
+
+			recordCacheEvents(res, req)
+
+			if res.Code != test.code {
+				t.Errorf("recording %d events returned %d, want %d", test.events, res.Code, test.code)
+			}
+		})
+	}
+
+	// Only the accepted events are counted
+	if stats := eventStats.Stats("team_blah"); stats.Hits != 100 {
+		t.Errorf("got %d hits, want 100", stats.Hits)
+	}
+}
+
+func TestEventFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	setupEvents(t, path, 1024)
+
+	stop, err := initEventSink()
+	if err != nil {
+		t.Fatalf("initEventSink() failed: %v", err)
+	}
+
+	// Every event is a line of about 130 bytes
+	event := cacheEvent{SessionID: "abc", Source: cacheSourceRemote, Event: cacheEventHit, Hash: "abc", Duration: 100}
+	for i := 0; i < 20; i++ {
+		if err := eventSink.Record("team_blah", []cacheEvent{event}); err != nil {
+			t.Fatalf("Record() failed: %v", err)
+		}
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("closing the events file failed: %v", err)
+	}
+
+	for _, file := range []string{path, rotatedEventFile(path)} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("os.Stat() failed: %v", err)
+		}
+		if info.Size() == 0 || info.Size() > 1024 {
+			t.Errorf("%s has %d bytes, want at most the maximum size of 1024 bytes", filepath.Base(file), info.Size())
+		}
+	}
+
+	// Only the events of the current and the rotated file are replayed
+	lines := countLines(t, path) + countLines(t, rotatedEventFile(path))
+	if lines == 0 || lines >= 20 {
+		t.Fatalf("the files have %d events, want the oldest events dropped", lines)
+	}
+
+	setupEvents(t, path, 1024)
+	stop, err = initEventSink()
+	if err != nil {
+		t.Fatalf("initEventSink() failed: %v", err)
+	}
+	defer stop()
+
+	if stats := eventStats.Stats("team_blah"); stats.Hits != int64(lines) {
+		t.Errorf("replayed %d hits, want %d", stats.Hits, lines)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile() failed: %v", err)
+	}
+	return strings.Count(string(contents), "\n")
+}
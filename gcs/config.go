@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/url"
+	"os"
 	"strings"
 
 	"cloud.google.com/go/storage"
@@ -87,6 +88,17 @@ func newGoogleStorageClient(config stow.Config) (context.Context, *storage.Clien
 	print("\nendpoint=", endpoint)
 
 	ctx := context.Background()
+
+	// The client connects to the emulator, e.g. fake-gcs-server, without
+	// authentication, passing credentials as well is rejected by the client
+	if os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ctx, client, nil
+	}
+
 	var creds *google.Credentials
 	var err error
 	if json != "" {
@@ -93,18 +93,24 @@ func (c *Container) Put(name string, r io.Reader, size int64, metadata map[strin
 		return nil, err
 	}
 
-	w := obj.NewWriter(c.ctx)
+	// The metadata is sent together with the contents of the object, this
+	// avoids a separate request to update the metadata afterwards.
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	w := obj.NewWriter(ctx)
+	w.Metadata = mdPrepped
 	if _, err := io.Copy(w, r); err != nil {
+		// Cancelling the context aborts the upload, closing the writer
+		// would store the partially written object instead.
+		cancel()
 		return nil, err
 	}
-	w.Close()
-
-	attr, err := obj.Update(c.ctx, storage.ObjectAttrsToUpdate{Metadata: mdPrepped})
-	if err != nil {
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
 
-	return c.convertToStowItem(attr)
+	return c.convertToStowItem(w.Attrs())
 }
 
 func (c *Container) convertToStowItem(attr *storage.ObjectAttrs) (stow.Item, error) {
@@ -43,6 +43,7 @@ func (l *Location) CreateContainer(containerName string) (stow.Container, error)
 			return &Container{
 				name:   containerName,
 				client: l.client,
+				ctx:    l.ctx,
 			}, nil
 		}
 
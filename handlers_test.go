@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestArtifactRoutesRejectInvalidHashes(t *testing.T) {
+	handlers := []struct {
+		method  string
+		handler http.HandlerFunc
+	}{
+		{http.MethodGet, readCacheItem},
+		{http.MethodHead, headCacheItem},
+		{http.MethodPut, writeCacheItem},
+		{http.MethodPost, writeCacheItem},
+	}
+
+	for _, storageKind := range []string{"memory", "local"} {
+		setupTenancy(t, storageKind, false, "{{.Hash}}")
+
+		for _, test := range handlers {
+			for _, hash := range []string{"..", ".", "../team_other/abc", "team_other/abc", `..\team_other`} {
+				req := httptest.NewRequest(test.method, "/v8/artifacts/abc?teamId=team_blah", strings.NewReader("contents"))
+				req = mux.SetURLVars(req, map[string]string{"artificateId": hash})
+				res := httptest.NewRecorder()
+
+				test.handler(res, req)
+
+				if res.Code != http.StatusBadRequest {
+					t.Errorf("%s %q on %s returned %d, want %d", test.method, hash, storageKind, res.Code, http.StatusBadRequest)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/graymeta/stow"
+)
+
+// The build information, set by goreleaser via -ldflags.
+var (
+	version = ""
+	commit  = "none"
+	date    = "unknown"
+)
+
+var readinessTimeout = app.Flag(
+	"readiness.timeout", "How long /readyz waits for the storage provider before reporting the server as not ready ($TURBO_READINESS_TIMEOUT).",
+).Envar("TURBO_READINESS_TIMEOUT").Default("5s").Duration()
+
+// readHealth reports that the process is alive, it never touches the
+// storage provider so a slow storage provider doesn't get the server
+// restarted.
+func readHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readReadiness reports whether the storage provider is reachable and the
+// bucket is accessible, so no traffic is routed to a server that can't serve
+// cache artefacts.
+func readReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), *readinessTimeout)
+	defer cancel()
+
+	err := probeStorage(ctx)
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("the storage provider didn't respond within %s", *readinessTimeout)
+	}
+
+	if err != nil {
+		logger.Log("message", "the storage provider is not ready", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// storageProbe is a check of the storage provider, err is set before done is
+// closed.
+type storageProbe struct {
+	done chan struct{}
+	err  error
+}
+
+// storageProbes holds the check of the storage provider in progress. The
+// storage provider clients can't be cancelled, so concurrent readiness checks
+// wait for the same check, a storage provider that doesn't respond leaves at
+// most one check behind.
+var storageProbes = struct {
+	sync.Mutex
+	running *storageProbe
+}{}
+
+// probeStorage checks the storage provider, it returns the error of the
+// context when the check doesn't finish in time.
+func probeStorage(ctx context.Context) error {
+	storageProbes.Lock()
+	probe := storageProbes.running
+	if probe == nil {
+		probe = &storageProbe{done: make(chan struct{})}
+		storageProbes.running = probe
+
+		go func() {
+			probe.err = checkStorage()
+
+			storageProbes.Lock()
+			storageProbes.running = nil
+			storageProbes.Unlock()
+			close(probe.done)
+		}()
+	}
+	storageProbes.Unlock()
+
+	select {
+	case <-probe.done:
+		return probe.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readinessProbeItem is the cache artefact looked up to check the storage
+// provider, it doesn't need to exist.
+const readinessProbeItem = "readyz"
+
+// checkStorage makes a cheap request to the storage provider. With a shared
+// bucket a single item of the bucket is looked up, a HEAD request or a stat
+// of a single file, a bucket or item that doesn't exist yet is fine as it's
+// created on the first upload. With a bucket per team, listing a single
+// bucket verifies the credentials.
+func checkStorage() error {
+	location, err := getLocation()
+	if err != nil {
+		return err
+	}
+
+	if *enableBucketPerTeam {
+		_, _, err = location.Containers("", stow.CursorStart, 1)
+		return err
+	}
+
+	// Not every storage provider makes a request when looking up a bucket
+	container, err := location.Container(*bucketName)
+	if err == stow.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = container.Item(readinessProbeItem)
+	if err == stow.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// getVersion returns the version of the server, binaries installed via go
+// install don't have the ldflags set but know the version of the module.
+func getVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		return build.Main.Version
+	}
+	return "0.0.1"
+}
+
+// readVersion returns the build information of the server.
+func readVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"version":   getVersion(),
+		"commit":    commit,
+		"date":      date,
+		"goVersion": runtime.Version(),
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	previousTimeout := *readinessTimeout
+	t.Cleanup(func() {
+		*readinessTimeout = previousTimeout
+	})
+	*readinessTimeout = time.Second
+
+	for _, storageKind := range []string{"memory", "local"} {
+		for _, perTeam := range []bool{false, true} {
+			setupTenancy(t, storageKind, perTeam, "{{.Hash}}")
+
+			res := httptest.NewRecorder()
+			readReadiness(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if res.Code != http.StatusOK {
+				t.Errorf("readiness on %s with a bucket per team %v returned %d, want %d", storageKind, perTeam, res.Code, http.StatusOK)
+			}
+		}
+	}
+}
+
+func TestProbeStorageTimeout(t *testing.T) {
+	// A check of the storage provider that doesn't respond
+	hanging := &storageProbe{done: make(chan struct{})}
+	storageProbes.Lock()
+	storageProbes.running = hanging
+	storageProbes.Unlock()
+	t.Cleanup(func() {
+		storageProbes.Lock()
+		storageProbes.running = nil
+		storageProbes.Unlock()
+	})
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := probeStorage(ctx)
+		cancel()
+
+		if err != context.DeadlineExceeded {
+			t.Errorf("probeStorage() returned %v, want %v", err, context.DeadlineExceeded)
+		}
+	}
+
+	// The readiness checks waited for the same check instead of starting
+	// another one
+	storageProbes.Lock()
+	running := storageProbes.running
+	storageProbes.Unlock()
+	if running != hanging {
+		t.Errorf("probeStorage() started another check of the storage provider")
+	}
+}
@@ -0,0 +1,55 @@
+package local
+
+import (
+	"errors"
+	"net/url"
+	"os"
+
+	"github.com/graymeta/stow"
+)
+
+// Kind represents the name of the location/storage type.
+const Kind = "local"
+
+const (
+	// The path to the directory where the containers are stored.
+	ConfigKeyPath = "path"
+)
+
+// metadataDir is the name of the directory inside a container that holds the
+// metadata of the items stored in the container.
+const metadataDir = ".metadata"
+
+func init() {
+	validatefn := func(config stow.Config) error {
+		_, ok := config.Config(ConfigKeyPath)
+		if !ok {
+			return errors.New("missing path config")
+		}
+		return nil
+	}
+	makefn := func(config stow.Config) (stow.Location, error) {
+		path, ok := config.Config(ConfigKeyPath)
+		if !ok {
+			return nil, errors.New("missing path config")
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, errors.New("path must be directory")
+		}
+
+		return &Location{
+			config: config,
+		}, nil
+	}
+
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == "file"
+	}
+
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
@@ -0,0 +1,281 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/graymeta/stow"
+)
+
+type Container struct {
+	// Name of the container, the path relative to the location.
+	name string
+
+	// Path is the absolute path of the directory of the container.
+	path string
+}
+
+// ID returns the absolute path of the container.
+func (c *Container) ID() string {
+	return c.path
+}
+
+// Name returns a string value which represents the name of the container.
+func (c *Container) Name() string {
+	return c.name
+}
+
+// URL returns the file URL of the container.
+func (c *Container) URL() *url.URL {
+	return &url.URL{
+		Scheme: "file",
+		Path:   filepath.Clean(c.path),
+	}
+}
+
+// Item returns a stow.Item instance of a container based on the
+// name of the item.
+func (c *Container) Item(id string) (stow.Item, error) {
+	path := id
+	if !filepath.IsAbs(id) {
+		path = filepath.Join(c.path, filepath.FromSlash(id))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, stow.ErrNotFound
+		}
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("unexpected directory")
+	}
+
+	if _, err := filepath.Rel(c.path, path); err != nil {
+		return nil, err
+	}
+
+	return c.newItem(path), nil
+}
+
+// Items retrieves a list of items that are prepended with
+// the prefix argument. The 'cursor' variable facilitates pagination.
+func (c *Container) Items(prefix string, cursor string, count int) ([]stow.Item, string, error) {
+	prefix = filepath.FromSlash(prefix)
+	files, err := flatdirs(c.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cursor != stow.CursorStart {
+		// seek to the cursor
+		ok := false
+		for i, file := range files {
+			if file == cursor {
+				files = files[i:]
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, "", stow.ErrBadCursor
+		}
+	}
+
+	if len(files) > count {
+		cursor = files[count]
+		files = files[:count]
+	} else {
+		cursor = "" // end
+	}
+
+	var items []stow.Item
+	for _, f := range files {
+		if !strings.HasPrefix(f, prefix) {
+			continue
+		}
+
+		path, err := filepath.Abs(filepath.Join(c.path, f))
+		if err != nil {
+			return nil, "", err
+		}
+
+		items = append(items, c.newItem(path))
+	}
+
+	return items, cursor, nil
+}
+
+// RemoveItem deletes the file of the item and its metadata.
+func (c *Container) RemoveItem(id string) error {
+	path := id
+	if !filepath.IsAbs(id) {
+		path = filepath.Join(c.path, filepath.FromSlash(id))
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(metadataPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Put stores the content of the reader in a file with the given name, the
+// metadata is stored in a separate JSON file.
+func (c *Container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	mdPrepped, err := prepMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(c.path, filepath.FromSlash(name))
+	tmpDir := filepath.Join(filepath.Dir(path), metadataDir)
+	if err := os.MkdirAll(tmpDir, 0777); err != nil {
+		return nil, err
+	}
+
+	// The contents are written to a temporary file first, so readers never
+	// see a partially written item
+	f, err := ioutil.TempFile(tmpDir, filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	// A negative size means the size is unknown up front
+	n, err := io.Copy(f, r)
+	if err == nil && size >= 0 && n != size {
+		err = errors.New("bad size")
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeMetadata(path, mdPrepped); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+
+	return c.newItem(path), nil
+}
+
+func (c *Container) newItem(path string) *Item {
+	return &Item{
+		path:          path,
+		contPrefixLen: len(c.path) + 1,
+	}
+}
+
+// flatdirs walks the entire tree returning a list of relative paths for all
+// files encountered, the metadata directories are skipped.
+func flatdirs(path string) ([]string, error) {
+	var list []string
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == metadataDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		flatname, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+
+		list = append(list, flatname)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// metadataPath returns the path of the file holding the metadata of the item
+// stored at the given path.
+func metadataPath(path string) string {
+	return filepath.Join(filepath.Dir(path), metadataDir, filepath.Base(path)+".json")
+}
+
+// writeMetadata stores the metadata of the item stored at the given path, any
+// stale metadata is removed when the item has no metadata.
+func writeMetadata(path string, metadata map[string]string) error {
+	mdPath := metadataPath(path)
+	if len(metadata) == 0 {
+		if err := os.Remove(mdPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	contents, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(mdPath), 0777); err != nil {
+		return err
+	}
+
+	return os.WriteFile(mdPath, contents, 0666)
+}
+
+// readMetadata returns the metadata of the item stored at the given path, an
+// empty map is returned when the item has no metadata.
+func readMetadata(path string) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{})
+
+	contents, err := os.ReadFile(metadataPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return metadata, nil
+		}
+		return nil, err
+	}
+
+	var parsed map[string]string
+	if err := json.Unmarshal(contents, &parsed); err != nil {
+		return nil, err
+	}
+
+	for key, value := range parsed {
+		metadata[key] = value
+	}
+
+	return metadata, nil
+}
+
+func prepMetadata(metadataParsed map[string]interface{}) (map[string]string, error) {
+	returnMap := make(map[string]string, len(metadataParsed))
+	for key, value := range metadataParsed {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf(`value of key '%s' in metadata must be of type string`, key)
+		}
+		returnMap[key] = str
+	}
+	return returnMap, nil
+}
@@ -0,0 +1,85 @@
+package local
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Item struct {
+	path          string
+	contPrefixLen int
+	infoOnce      sync.Once // protects info and metadata
+	info          os.FileInfo
+	metadata      map[string]interface{}
+	infoErr       error
+}
+
+// ID returns the absolute path of the file.
+func (i *Item) ID() string {
+	return i.path
+}
+
+// Name returns the path of the file relative to the container.
+func (i *Item) Name() string {
+	return filepath.ToSlash(i.path[i.contPrefixLen:])
+}
+
+// Size returns the size of an item in bytes.
+func (i *Item) Size() (int64, error) {
+	if err := i.ensureInfo(); err != nil {
+		return 0, err
+	}
+	return i.info.Size(), nil
+}
+
+// URL returns the file URL of the item.
+func (i *Item) URL() *url.URL {
+	return &url.URL{
+		Scheme: "file",
+		Path:   filepath.Clean(i.path),
+	}
+}
+
+// ETag returns the modification time of the file as ETag value.
+func (i *Item) ETag() (string, error) {
+	if err := i.ensureInfo(); err != nil {
+		return "", err
+	}
+	return i.info.ModTime().String(), nil
+}
+
+// Open opens the file for reading.
+func (i *Item) Open() (io.ReadCloser, error) {
+	return os.Open(i.path)
+}
+
+// LastMod returns the last modified date of the file.
+func (i *Item) LastMod() (time.Time, error) {
+	if err := i.ensureInfo(); err != nil {
+		return time.Time{}, err
+	}
+	return i.info.ModTime(), nil
+}
+
+// Metadata returns the metadata that was stored together with the item.
+func (i *Item) Metadata() (map[string]interface{}, error) {
+	if err := i.ensureInfo(); err != nil {
+		return nil, err
+	}
+	return i.metadata, nil
+}
+
+func (i *Item) ensureInfo() error {
+	i.infoOnce.Do(func() {
+		i.info, i.infoErr = os.Lstat(i.path)
+		if i.infoErr != nil {
+			return
+		}
+		i.metadata, i.infoErr = readMetadata(i.path)
+	})
+	return i.infoErr
+}
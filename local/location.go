@@ -0,0 +1,165 @@
+package local
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/graymeta/stow"
+)
+
+// A Location represents a directory on the local file system, each directory
+// inside of it is a container.
+type Location struct {
+	config stow.Config
+}
+
+// Close simply satisfies the Location interface. There's nothing that
+// needs to be done in order to satisfy the interface.
+func (l *Location) Close() error {
+	return nil // nothing to close
+}
+
+// ItemByURL retrieves a stow.Item by the path of the given file URL.
+func (l *Location) ItemByURL(u *url.URL) (stow.Item, error) {
+	dir, _ := filepath.Split(u.Path)
+	return &Item{
+		path:          u.Path,
+		contPrefixLen: len(dir),
+	}, nil
+}
+
+// RemoveContainer removes the directory of the container and its contents.
+func (l *Location) RemoveContainer(id string) error {
+	return os.RemoveAll(id)
+}
+
+// CreateContainer creates a new container, in this case a directory.
+func (l *Location) CreateContainer(name string) (stow.Container, error) {
+	path, ok := l.config.Config(ConfigKeyPath)
+	if !ok {
+		return nil, errors.New("missing " + ConfigKeyPath + " configuration")
+	}
+
+	fullpath := filepath.Join(path, name)
+	if err := os.Mkdir(fullpath, 0777); err != nil {
+		return nil, err
+	}
+
+	abspath, err := filepath.Abs(fullpath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Container{
+		name: name,
+		path: abspath,
+	}, nil
+}
+
+// Containers returns a slice of the Container interface, a cursor, and an error.
+func (l *Location) Containers(prefix string, cursor string, count int) ([]stow.Container, string, error) {
+	path, ok := l.config.Config(ConfigKeyPath)
+	if !ok {
+		return nil, "", errors.New("missing " + ConfigKeyPath + " configuration")
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, prefix+"*"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	cs, err := l.filesToContainers(path, files...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cursor != stow.CursorStart {
+		// seek to the cursor
+		ok := false
+		for i, c := range cs {
+			if c.ID() == cursor {
+				ok = true
+				cs = cs[i:]
+				break
+			}
+		}
+		if !ok {
+			return nil, "", stow.ErrBadCursor
+		}
+	}
+
+	if len(cs) > count {
+		cursor = cs[count].ID()
+		cs = cs[:count]
+	} else {
+		cursor = ""
+	}
+
+	return cs, cursor, nil
+}
+
+// Container retrieves a stow.Container based on its name which must be
+// exact.
+func (l *Location) Container(id string) (stow.Container, error) {
+	path, ok := l.config.Config(ConfigKeyPath)
+	if !ok {
+		return nil, errors.New("missing " + ConfigKeyPath + " configuration")
+	}
+
+	fullPath := id
+	if !filepath.IsAbs(id) {
+		fullPath = filepath.Join(path, id)
+	}
+
+	containers, err := l.filesToContainers(path, fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, stow.ErrNotFound
+		}
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, stow.ErrNotFound
+	}
+
+	return containers[0], nil
+}
+
+// filesToContainers takes a list of files and turns it into a list of
+// containers, files which are not a directory are skipped.
+func (l *Location) filesToContainers(root string, files ...string) ([]stow.Container, error) {
+	absroot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := make([]stow.Container, 0, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			continue
+		}
+
+		path, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := filepath.Rel(absroot, path)
+		if err != nil {
+			return nil, err
+		}
+
+		cs = append(cs, &Container{
+			name: name,
+			path: path,
+		})
+	}
+
+	return cs, nil
+}
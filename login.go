@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var loginTokenTTL = app.Flag(
+	"login.token-ttl", "How long the tokens issued by `turbo login` are accepted, afterwards the developer has to log in again ($TURBO_LOGIN_TOKEN_TTL).",
+).Envar("TURBO_LOGIN_TOKEN_TTL").Default("720h").Duration()
+
+// loginTokenPrefix is the prefix of the tokens issued by the login flow, it
+// makes it easy to tell them apart from the tokens passed via --turbo-token.
+const loginTokenPrefix = "tlc_"
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Tapico Turborepo Remote Cache</title></head>
+<body>
+	<h1>Log in to the Turborepo remote cache</h1>
+	{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+	<form method="POST" action="/turborepo/token">
+		<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+		<label for="secret">Login secret</label>
+		<input type="password" id="secret" name="secret" autofocus>
+		<button type="submit">Log in</button>
+	</form>
+</body>
+</html>
+`))
+
+var loginSuccessPage = []byte(`<!DOCTYPE html>
+<html>
+<head><title>Tapico Turborepo Remote Cache</title></head>
+<body>
+	<h1>Turborepo CLI authorised</h1>
+	<p>You can close this page and return to your terminal.</p>
+</body>
+</html>
+`)
+
+// loginTokenKey returns the key used to sign the tokens issued by the login
+// flow, it's derived from the login secret so no state needs to be stored.
+func loginTokenKey() []byte {
+	key := sha256.Sum256([]byte("tapico-turborepo-remote-cache:" + configuredAccounts.LoginSecret))
+	return key[:]
+}
+
+// The signed payload of a login token is a random token id followed by the
+// expiry as Unix time in seconds.
+const (
+	loginTokenIDSize      = 16
+	loginTokenPayloadSize = loginTokenIDSize + 8
+)
+
+// issueLoginToken creates a new token signed by the server, it returns the
+// token and its id, which identifies the token in the logs.
+func issueLoginToken(now time.Time) (string, string, error) {
+	payload := make([]byte, loginTokenPayloadSize)
+	if _, err := rand.Read(payload[:loginTokenIDSize]); err != nil {
+		return "", "", err
+	}
+	binary.BigEndian.PutUint64(payload[loginTokenIDSize:], uint64(now.Add(*loginTokenTTL).Unix()))
+
+	mac := hmac.New(sha256.New, loginTokenKey())
+	mac.Write(payload)
+
+	token := loginTokenPrefix + base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return token, hex.EncodeToString(payload[:loginTokenIDSize]), nil
+}
+
+// parseLoginToken checks whether the token was issued by the login flow and
+// hasn't expired, it returns the id of the token.
+func parseLoginToken(token string, now time.Time) (string, bool) {
+	if configuredAccounts == nil || configuredAccounts.LoginSecret == "" || !strings.HasPrefix(token, loginTokenPrefix) {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, loginTokenPrefix), ".")
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) != loginTokenPayloadSize {
+		return "", false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+
+	mac := hmac.New(sha256.New, loginTokenKey())
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", false
+	}
+
+	id := hex.EncodeToString(payload[:loginTokenIDSize])
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload[loginTokenIDSize:])), 0)
+	if !now.Before(expiry) {
+		logger.Log("message", "received an expired login token", "tokenID", id, "expiry", expiry)
+		return "", false
+	}
+
+	return id, true
+}
+
+// loginPrincipal returns the principal of the tokens issued by the login flow,
+// which only have access to the teams defined via --accounts.config.
+func loginPrincipal(id string) *principal {
+	who := &principal{Name: "login " + id, Teams: make([]string, 0, len(configuredAccounts.Teams))}
+	for _, team := range configuredAccounts.Teams {
+		who.Teams = append(who.Teams, team.ID)
+	}
+
+	if len(configuredAccounts.LoginPermissions) == 0 {
+		who.Read, who.Write = true, true
+	}
+	for _, permission := range configuredAccounts.LoginPermissions {
+		switch permission {
+		case permissionRead:
+			who.Read = true
+		case permissionWrite:
+			who.Write = true
+		}
+	}
+	return who
+}
+
+// isLoopbackRedirect only allows redirecting the token to the server turbo
+// starts on the machine of the developer, to prevent leaking the token.
+func isLoopbackRedirect(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isLoginEnabled() bool {
+	return configuredAccounts != nil && configuredAccounts.LoginSecret != ""
+}
+
+// showLogin renders the page `turbo login` opens in the browser.
+func showLogin(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "showLogin()")
+
+	if !isLoginEnabled() {
+		http.Error(w, "login is not enabled on this server", http.StatusNotFound)
+		return
+	}
+
+	redirectURI := r.URL.Query().Get("redirect_uri")
+	if !isLoopbackRedirect(redirectURI) {
+		http.Error(w, "redirect_uri must point to the local turbo login server", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := loginPage.Execute(w, map[string]string{"RedirectURI": redirectURI}); err != nil {
+		logger.Log("message", "failed to render login page", "error", err)
+	}
+}
+
+// submitLogin verifies the login secret and sends the issued token back to
+// the server started by `turbo login`.
+func submitLogin(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "submitLogin()")
+
+	if !isLoginEnabled() {
+		http.Error(w, "login is not enabled on this server", http.StatusNotFound)
+		return
+	}
+
+	redirectURI := r.PostFormValue("redirect_uri")
+	if !isLoopbackRedirect(redirectURI) {
+		http.Error(w, "redirect_uri must point to the local turbo login server", http.StatusBadRequest)
+		return
+	}
+
+	if delay := authFailureDelay(r); delay > 0 {
+		seconds := retryAfterSeconds(w, delay)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		if err := loginPage.Execute(w, map[string]string{"RedirectURI": redirectURI, "Error": "Too many failed attempts, retry after " + seconds + " seconds"}); err != nil {
+			logger.Log("message", "failed to render login page", "error", err)
+		}
+		return
+	}
+
+	secret := r.PostFormValue("secret")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(configuredAccounts.LoginSecret)) != 1 {
+		logger.Log("message", "login attempt with invalid secret", "remoteAddr", r.RemoteAddr)
+		recordAuthFailure(r)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		if err := loginPage.Execute(w, map[string]string{"RedirectURI": redirectURI, "Error": "Invalid login secret"}); err != nil {
+			logger.Log("message", "failed to render login page", "error", err)
+		}
+		return
+	}
+
+	token, id, err := issueLoginToken(time.Now())
+	if err != nil {
+		logger.Log("message", "failed to issue token", "error", err)
+		http.Error(w, "failed to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	logger.Log("message", "issued token via login flow", "tokenID", id, "ttl", *loginTokenTTL, "remoteAddr", r.RemoteAddr)
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// showLoginSuccess is the page turbo redirects to after receiving the token.
+func showLoginSuccess(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(loginSuccessPage)
+}
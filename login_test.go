@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// setupLogin enables the login flow for a test, the previous values are
+// restored when the test finishes.
+func setupLogin(t *testing.T, secret string, permissions []string) {
+	t.Helper()
+
+	previousLogger, previousAccounts, previousTTL := logger, configuredAccounts, *loginTokenTTL
+	t.Cleanup(func() {
+		logger, configuredAccounts, *loginTokenTTL = previousLogger, previousAccounts, previousTTL
+	})
+
+	logger = log.NewNopLogger()
+	configuredAccounts = &accounts{
+		Teams:            []accountTeam{{ID: "team_blah", Slug: "blah"}, {ID: "team_other", Slug: "other"}},
+		LoginSecret:      secret,
+		LoginPermissions: permissions,
+	}
+	*loginTokenTTL = time.Hour
+}
+
+func TestParseLoginToken(t *testing.T) {
+	setupLogin(t, "s3cret", nil)
+
+	issuedAt := time.Unix(1700000000, 0)
+	token, id, err := issueLoginToken(issuedAt)
+	if err != nil {
+		t.Fatalf("issueLoginToken() failed: %v", err)
+	}
+
+	// A token signed before the expiry was part of the signed payload
+	legacyNonce := make([]byte, 18)
+	mac := hmac.New(sha256.New, loginTokenKey())
+	mac.Write(legacyNonce)
+	legacy := loginTokenPrefix + base64.RawURLEncoding.EncodeToString(legacyNonce) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	// Extending the expiry invalidates the signature
+	parts := strings.Split(strings.TrimPrefix(token, loginTokenPrefix), ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	binary.BigEndian.PutUint64(payload[loginTokenIDSize:], uint64(issuedAt.Add(24*time.Hour).Unix()))
+	extended := loginTokenPrefix + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[1]
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		valid bool
+	}{
+		{name: "valid", token: token, now: issuedAt.Add(time.Minute), valid: true},
+		{name: "just before the expiry", token: token, now: issuedAt.Add(time.Hour - time.Second), valid: true},
+		{name: "expired", token: token, now: issuedAt.Add(time.Hour)},
+		{name: "extended expiry", token: extended, now: issuedAt.Add(2 * time.Hour)},
+		{name: "without expiry", token: legacy, now: issuedAt},
+		{name: "without prefix", token: strings.TrimPrefix(token, loginTokenPrefix), now: issuedAt},
+		{name: "without signature", token: loginTokenPrefix + parts[0], now: issuedAt},
+		{name: "not base64", token: loginTokenPrefix + "!." + parts[1], now: issuedAt},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsedID, ok := parseLoginToken(test.token, test.now)
+			if ok != test.valid {
+				t.Fatalf("parseLoginToken() = %v, want %v", ok, test.valid)
+			}
+			if ok && parsedID != id {
+				t.Errorf("got token id %q, want %q", parsedID, id)
+			}
+		})
+	}
+
+	// Changing the login secret revokes all issued tokens
+	configuredAccounts.LoginSecret = "another secret"
+	if _, ok := parseLoginToken(token, issuedAt); ok {
+		t.Errorf("parseLoginToken() accepted a token signed with the previous login secret")
+	}
+}
+
+func TestIssueLoginTokenIDs(t *testing.T) {
+	setupLogin(t, "s3cret", nil)
+
+	_, first, err := issueLoginToken(time.Now())
+	if err != nil {
+		t.Fatalf("issueLoginToken() failed: %v", err)
+	}
+	_, second, err := issueLoginToken(time.Now())
+	if err != nil {
+		t.Fatalf("issueLoginToken() failed: %v", err)
+	}
+
+	if first == second || len(first) != 2*loginTokenIDSize {
+		t.Errorf("got token ids %q and %q, want two different ids", first, second)
+	}
+}
+
+func TestLoginPrincipal(t *testing.T) {
+	tests := []struct {
+		permissions []string
+		read        bool
+		write       bool
+	}{
+		{permissions: nil, read: true, write: true},
+		{permissions: []string{permissionRead}, read: true},
+		{permissions: []string{permissionWrite}, write: true},
+		{permissions: []string{permissionRead, permissionWrite}, read: true, write: true},
+	}
+
+	for _, test := range tests {
+		setupLogin(t, "s3cret", test.permissions)
+
+		who := loginPrincipal("abc")
+		if who.Read != test.read || who.Write != test.write {
+			t.Errorf("with the permissions %v got read %v and write %v, want %v and %v", test.permissions, who.Read, who.Write, test.read, test.write)
+		}
+		if !equalStrings(who.Teams, []string{"team_blah", "team_other"}) {
+			t.Errorf("got teams %v, want the configured teams", who.Teams)
+		}
+		if !who.allowsTeam("blah") || who.allowsTeam("team_unknown") {
+			t.Errorf("the login token doesn't have access to exactly the configured teams")
+		}
+	}
+}
@@ -2,54 +2,63 @@ package main
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
+	gohash "hash"
 	"io"
+	"io/ioutil"
 	stdlog "log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/go-kit/log"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/baggage"
-	stdout "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
-	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/resource"
-	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
 	oteltrace "go.opentelemetry.io/otel/trace"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	// Routing and Cloud storage.
+	"tapico-turborepo-remote-cache/azure"
 	"tapico-turborepo-remote-cache/gcs"
+	"tapico-turborepo-remote-cache/local"
+	"tapico-turborepo-remote-cache/memory"
 
 	"github.com/gorilla/mux"
 	"github.com/graymeta/stow"
-	"github.com/graymeta/stow/local"
 	"github.com/graymeta/stow/s3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var logger log.Logger
 
+const (
+	// metadataArtifactDuration is the metadata key used to store the value of
+	// the `x-artifact-duration` header of an uploaded cache artefact.
+	metadataArtifactDuration = "artifact-duration"
+
+	// metadataArtifactTag is the metadata key used to store the value of the
+	// `x-artifact-tag` header, the signature of a signed cache artefact.
+	metadataArtifactTag = "artifact-tag"
+)
+
 var (
 	app     = kingpin.New("tapico-turborepo-remote-cache", "A tool to work with Vercel Turborepo to upload/retrieve cache artefacts to/from popular cloud providers")
 	verbose = app.Flag("verbose", "Verbose mode.").Short('v').Bool()
-	kind    = app.Flag("kind", "Kind of storage provider to use (s3, gcs, local). ($CLOUD_PROVIDER_KIND)").Default("s3").Envar("CLOUD_PROVIDER_KIND").String()
+	kind    = app.Flag("kind", "Kind of storage provider to use (s3, gcs, azure, local, memory). ($CLOUD_PROVIDER_KIND)").Default("s3").Envar("CLOUD_PROVIDER_KIND").String()
 
 	useSecure = app.Flag("secure", "Enable secure access (or HTTPs endpoints).").Envar("CLOUD_SECURE").Bool()
 
 	bucketName = app.Flag("bucket", "The name of the bucket ($BUCKET_NAME)").Envar("BUCKET_NAME").Default("tapico-remote-cache").String()
 
-	enableBucketPerTeam = app.Flag("enable-bucket-per-team", "The name of the bucket").Bool()
+	enableBucketPerTeam = app.Flag("enable-bucket-per-team", "Store the cache artefacts of each team in its own bucket").Bool()
 
-	allowedTurboTokens = app.Flag("turbo-token", "The comma separated list of TURBO_TOKEN that the server should accept ($TURBO_TOKEN)").Envar("TURBO_TOKEN").Required().String()
+	allowedTurboTokens = app.Flag("turbo-token", "The comma separated list of TURBO_TOKEN that the server should accept, required by serve unless --tokens.file, --tls.client-ca or --oidc.issuer is given ($TURBO_TOKEN)").Envar("TURBO_TOKEN").String()
 
 	googleEndpoint = app.Flag("google.endpoint", "API Endpoint of cloud storage provide to use ($GOOGLE_ENDPOINT)").Envar("GOOGLE_ENDPOINT").String()
 
@@ -80,15 +89,46 @@ var (
 	awsRegionName = app.Flag(
 		"s3.region", "The Amazon S3 region($AWS_S3_REGION_NAME).",
 	).Envar("AWS_S3_REGION_NAME").String()
+
+	azureAccountName = app.Flag(
+		"azure.account", "The name of the Azure Storage account, use devstoreaccount1 for Azurite ($AZURE_STORAGE_ACCOUNT).",
+	).Envar("AZURE_STORAGE_ACCOUNT").String()
+
+	azureAccountKey = app.Flag(
+		"azure.key", "The shared key of the Azure Storage account ($AZURE_STORAGE_KEY).",
+	).Envar("AZURE_STORAGE_KEY").String()
+
+	azureSASToken = app.Flag(
+		"azure.sas-token", "The SAS token to use instead of the shared key of the Azure Storage account ($AZURE_STORAGE_SAS_TOKEN).",
+	).Envar("AZURE_STORAGE_SAS_TOKEN").String()
+
+	azureConnectionString = app.Flag(
+		"azure.connection-string", "The connection string of the Azure Storage account, takes precedence over the other Azure arguments ($AZURE_STORAGE_CONNECTION_STRING).",
+	).Envar("AZURE_STORAGE_CONNECTION_STRING").String()
+
+	azureEndpointSuffix = app.Flag(
+		"azure.endpoint-suffix", "The endpoint suffix of Azure Storage for sovereign clouds, defaults to core.windows.net ($AZURE_STORAGE_ENDPOINT_SUFFIX).",
+	).Envar("AZURE_STORAGE_ENDPOINT_SUFFIX").String()
+
+	memoryMaxSize = app.Flag(
+		"memory.max-size", "The maximum size of the cache artefacts kept in memory when 'memory' is enabled, the least recently used cache artefacts are removed when exceeded ($TURBO_MEMORY_MAX_SIZE).",
+	).Envar("TURBO_MEMORY_MAX_SIZE").Default("512MB").Bytes()
 )
 
-func GetBucketName(name string) string {
-	if *enableBucketPerTeam {
-		hash := md5.Sum([]byte(name))
-		return hex.EncodeToString(hash[:])
-	}
+var (
+	serveCommand     = app.Command("serve", "Start the remote cache server (default).").Default()
+	gcCommand        = app.Command("gc", "Remove the cache artefacts that are older than the maximum age.")
+	hashTokenCommand = app.Command("hash-token", "Print the hash of the token read from the standard input, to use in the file passed via --tokens.file.")
+)
 
-	return name
+// secretConfigKeys are the configuration values of the storage providers that
+// contain credentials.
+var secretConfigKeys = map[string]bool{
+	s3.ConfigSecretKey:           true,
+	gcs.ConfigJSON:               true,
+	azure.ConfigKey:              true,
+	azure.ConfigSASToken:         true,
+	azure.ConfigConnectionString: true,
 }
 
 func getProviderConfig(kind string) (stow.ConfigMap, error) {
@@ -145,8 +185,6 @@ func getProviderConfig(kind string) (stow.ConfigMap, error) {
 		// 	googleCredentialsContents = fileContents
 		// }
 
-		logger.Log("contents", string(googleCredentialsContents))
-
 		config = stow.ConfigMap{
 			gcs.ConfigProjectId: *googleProjectID,
 			gcs.ConfigJSON:      string(googleCredentialsContents),
@@ -157,6 +195,25 @@ func getProviderConfig(kind string) (stow.ConfigMap, error) {
 			config[gcs.ConfigEndpoint] = *googleEndpoint
 		}
 
+	} else if kind == "azure" {
+		logger.Log("message", "getting provider for Azure Blob Storage")
+
+		config = stow.ConfigMap{
+			azure.ConfigAccount:          *azureAccountName,
+			azure.ConfigKey:              *azureAccountKey,
+			azure.ConfigSASToken:         *azureSASToken,
+			azure.ConfigConnectionString: *azureConnectionString,
+		}
+
+		if *azureEndpointSuffix != "" {
+			config[azure.ConfigBaseURL] = *azureEndpointSuffix
+		}
+	} else if kind == "memory" {
+		logger.Log("message", "getting provider for Memory")
+
+		config = stow.ConfigMap{
+			memory.ConfigMaxBytes: strconv.FormatInt(int64(*memoryMaxSize), 10),
+		}
 	} else {
 		logger.Log("message", "getting provider for Local Filesystem")
 		configPath, _ := filepath.Abs(*localStoragePath)
@@ -167,10 +224,14 @@ func getProviderConfig(kind string) (stow.ConfigMap, error) {
 		}
 	}
 
-	// iterate through the list of config mappings and dump the values for debugging purposes
+	// iterate through the list of config mappings and dump the values for debugging purposes,
+	// the credentials are never logged
 	if *verbose {
 		for key, val := range config {
 			//	fmt.Printf("Key: %d, Value: %s\n", key, val)
+			if secretConfigKeys[key] && val != "" {
+				val = "<redacted>"
+			}
 			logger.Log("key", key, "value", val)
 		}
 	}
@@ -179,55 +240,21 @@ func getProviderConfig(kind string) (stow.ConfigMap, error) {
 }
 
 func GetContainerByName(name string) (stow.Container, error) {
-	config, err := getProviderConfig(*kind)
-	if err != nil {
-		return nil, err
-	}
-
-	// connect
-	location, err := stow.Dial(*kind, config)
+	location, err := getLocation()
 	if err != nil {
 		return nil, err
 	}
 
-	containers, item, err := location.Containers("", "", 100)
-	logger.Log("item", item)
-	for _, v := range containers {
-		logger.Log("message", "get container name", "value", v.Name())
-	}
-
-	if err != nil {
-		logger.Log("error", err)
-	} else {
-		for _, v := range containers {
-			logger.Log("message", "list of containers", "container", v)
-		}
-	}
-
-	var container stow.Container
-
-	logger.Log("message", "the name of the bucket is", "bucket", bucketName)
+	logger.Log("message", "the name of the bucket is", "bucket", name)
 
-	receivedContainer, err := location.Container(*bucketName)
+	container, err := containers.Get(location, name)
 	if err != nil {
-		logger.Log("message", "failed to fetch existing container with the requested name")
-		logger.Log("error", err)
-	} else {
-		logger.Log("message", "found existing container")
-		container = receivedContainer
+		return nil, err
 	}
 
-	if receivedContainer == nil {
-		logger.Log("message", "failed to find an existing container")
-		createdContainer, err := location.CreateContainer(*bucketName)
-		if err != nil {
-			logger.Log("message", "failed to create container")
-			logger.Log(err)
-			return nil, err
-		}
-
-		logger.Log("message", "create the container for storing cache items")
-		container = createdContainer
+	container = instrumentContainer(container)
+	if tier != nil {
+		container = tier.Wrap(container)
 	}
 
 	logger.Log("message", fmt.Sprintf(`GetContainerByName() id: %s`, container.ID()))
@@ -236,14 +263,16 @@ func GetContainerByName(name string) (stow.Container, error) {
 	return container, nil
 }
 
-func createCacheBlob(name string, teamID string, fileContents io.Reader, fileSize int64) (stow.Item, string, error) {
+func createCacheBlob(ctx context.Context, name string, team tenant, fileContents io.Reader, fileSize int64, metadata map[string]interface{}) (stow.Item, string, error) {
 	logger.Log("message", "createCacheBlob() called")
 
-	bucketName := GetBucketName(teamID)
+	fullArtefactPath := team.ArtefactPath(name)
 
-	container, err := GetContainerByName(bucketName)
+	_, span := startStorageSpan(ctx, "container", team, fullArtefactPath)
+	container, err := GetContainerByName(team.Bucket)
+	endStorageSpan(span, err)
 	if err != nil {
-		logger.Log("failed to get container by name", bucketName)
+		logger.Log("message", "failed to get container by name", "bucket", team.Bucket)
 		return nil, "", err
 	}
 
@@ -253,15 +282,14 @@ func createCacheBlob(name string, teamID string, fileContents io.Reader, fileSiz
 		return nil, "", nil
 	}
 
-	fullArtefactPath := fmt.Sprintf("%s/%s", teamID, name) //nolint
-	if *enableBucketPerTeam {
-		fullArtefactPath = fmt.Sprintf("%s", name) //nolint
-	}
 	logger.Log("message", "The full path where to store the artefact item", "path", fullArtefactPath)
 
 	//
 	logger.Log("message", "attempt to save item to cloud storage")
-	item, err := container.Put(fullArtefactPath, fileContents, fileSize, nil)
+	_, span = startStorageSpan(ctx, "put", team, fullArtefactPath)
+	span.SetAttributes(attribute.Int64("storage.size", fileSize))
+	item, err := container.Put(fullArtefactPath, fileContents, fileSize, metadata)
+	endStorageSpan(span, err)
 	if err != nil {
 		logger.Log("message", "failed to save item to cloud storage")
 		logger.Log("error", err)
@@ -282,12 +310,14 @@ func createCacheBlob(name string, teamID string, fileContents io.Reader, fileSiz
 	return item, fullArtefactPath, nil
 }
 
-func readCacheBlob(name string, teamID string) (stow.Item, error) {
+func readCacheBlob(ctx context.Context, name string, team tenant) (stow.Item, error) {
 	logger.Log("message", "readCacheBlob() called")
 
-	bucketName := GetBucketName(teamID)
+	fullArtefactPath := team.ArtefactPath(name)
 
-	container, err := GetContainerByName(bucketName)
+	_, span := startStorageSpan(ctx, "container", team, fullArtefactPath)
+	container, err := GetContainerByName(team.Bucket)
+	endStorageSpan(span, err)
 	if err != nil {
 		logger.Log("message", "failed to get container api instance")
 		logger.Log(err)
@@ -302,16 +332,13 @@ func readCacheBlob(name string, teamID string) (stow.Item, error) {
 		return nil, nil
 	}
 
-	//
-	fullArtefactPath := fmt.Sprintf("%s/%s", teamID, name)
-	if *enableBucketPerTeam {
-		fullArtefactPath = fmt.Sprintf("%s", name) //nolint
-	}
 	logger.Log("message", "The full path where to store the artefact item", "path", fullArtefactPath)
 
 	//
 	logger.Log("message", "attempt to read item from cloud storage")
+	_, span = startStorageSpan(ctx, "item", team, fullArtefactPath)
 	item, err := container.Item(fullArtefactPath)
+	endStorageSpan(span, err)
 	if err != nil {
 		logger.Log("message", "failed to read item from cloud storage")
 		if err == stow.ErrNotFound {
@@ -337,6 +364,16 @@ func readCacheBlob(name string, teamID string) (stow.Item, error) {
 	return item, nil
 }
 
+// getTeamID returns the team the request is made for, if teamId and slug are
+// defined, we use slug over teamId.
+func getTeamID(query url.Values) string {
+	if query.Has("slug") {
+		return query.Get("slug")
+	}
+
+	return query.Get("teamId")
+}
+
 func readCacheItem(w http.ResponseWriter, r *http.Request) {
 	logger.Log("message", "readCacheItem()")
 	pathParams := mux.Vars(r)
@@ -362,6 +399,14 @@ func readCacheItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The hash is used as path of the cache artefact, so it must not refer to
+	// the cache artefacts of another team
+	if !isSafePathSegment(artificateID) {
+		logger.Log("message", "rejecting the request for an invalid hash", "artificateID", artificateID)
+		writeInvalidHashError(w, artificateID)
+		return
+	}
+
 	query := r.URL.Query()
 	if !query.Has("teamId") && !query.Has("slug") {
 		w.WriteHeader(http.StatusPreconditionFailed)
@@ -374,19 +419,31 @@ func readCacheItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If teamId and slug are defined, we use slug over teamId
-	teamID := query.Get("teamId")
-	if query.Has("slug") {
-		teamID = query.Get("slug")
+	teamID := getTeamID(query)
+	team, err := resolveTenant(teamID)
+	if err != nil {
+		logger.Log("message", "failed to resolve the bucket of the team", "teamID", teamID, "error", err)
+		writeInvalidTeamError(w, err)
+		return
+	}
+	logger.Log("message", fmt.Sprintf("received the following teamID=%s bucket=%s", teamID, team.Bucket))
+
+	// Report a cache miss when caching is disabled or paused for the team
+	if !isCacheReadable(teamID) {
+		logger.Log("message", "sending 404 as remote caching is not enabled for the team", "teamID", teamID, "status", getCacheStatus(teamID))
+		recordCacheResult(r, teamID, cacheResultMiss)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"message":"Artifact not found","code":"not_found"}}`))
+		return
 	}
-	sanitisedteamID := GetBucketName(teamID)
-	logger.Log("message", fmt.Sprintf("received the following teamID=%s sanitisedteamID=%s", teamID, sanitisedteamID))
 
 	// Attempt to return the data from the cloud storage
-	item, err := readCacheBlob(artificateID, sanitisedteamID)
+	item, err := readCacheBlob(ctx, artificateID, team)
 	if err != nil {
 		logger.Log("message", "sending 404 as error occurred while reading cahe item", "error", err.Error())
 		logger.Log(err)
+		recordCacheResult(r, teamID, cacheResultMiss)
 
 		w.WriteHeader(http.StatusNotFound)
 		w.Header().Set("Content-Type", "application/json")
@@ -394,8 +451,12 @@ func readCacheItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAccess(team.Bucket, team.ArtefactPath(artificateID), time.Now())
+
 	// Attempt to read the file contents of the artificats
+	_, openSpan := startStorageSpan(ctx, "open", team, team.ArtefactPath(artificateID))
 	fileReference, err := item.Open()
+	endStorageSpan(openSpan, err)
 	if err != nil {
 		defer fileReference.Close()
 
@@ -408,22 +469,217 @@ func readCacheItem(w http.ResponseWriter, r *http.Request) {
 
 	defer fileReference.Close()
 
-	w.WriteHeader((http.StatusOK))
+	// Verify the signature of the cache artefact before serving it, this
+	// requires the full contents to be read up front. The contents are stored
+	// in a temporary file instead of in memory, as cache artefacts can be large.
+	var contents io.Reader = fileReference
+	if *enableSignatureVerification {
+		// The secret is looked up by the team turbo signed the cache
+		// artefact with, which can differ from the slug the bucket is
+		// resolved with
+		signingTeamID := signatureTeamID(query)
+		key, ok := getSignatureKey(signingTeamID)
+		if !ok {
+			logger.Log("message", "no signature key configured for team", "teamID", signingTeamID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"message":"no signature key configured for team","code":"forbidden"}}`))
+			return
+		}
+
+		signature, err := newArtifactSignature(key, artificateID, signingTeamID)
+		if err != nil {
+			logger.Log("message", "failed to calculate the signature of the cache item", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"failed to verify the signature of the artifact","code":"internal_error"}}`))
+			return
+		}
+
+		_, readSpan := startStorageSpan(ctx, "read", team, team.ArtefactPath(artificateID))
+		download, err := spoolUpload(fileReference, signature)
+		if download != nil {
+			readSpan.SetAttributes(attribute.Int64("storage.size", download.size))
+		}
+		endStorageSpan(readSpan, err)
+		if err != nil {
+			logger.Log("message", "error occurred while reading cache item from cloud storage", "error", err.Error())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"failed to read cache item","code":"internal_error"}}`))
+			return
+		}
+		defer download.Close()
+
+		if !verifyArtifactSignature(signature, getItemMetadata(item, metadataArtifactTag)) {
+			logger.Log("message", "refusing to serve cache item with invalid signature", "artificateID", artificateID, "teamID", teamID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"message":"the signature of the artifact is invalid","code":"invalid_signature"}}`))
+			return
+		}
+
+		contents = download
+	}
+
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Accept, Content-Type")
 	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+	setArtifactHeaders(w, item)
+	w.WriteHeader((http.StatusOK))
+
+	// Without signature verification the contents are streamed from the
+	// storage provider while writing the response
+	var copySpan oteltrace.Span
+	if !*enableSignatureVerification {
+		_, copySpan = startStorageSpan(ctx, "read", team, team.ArtefactPath(artificateID))
+	}
+
+	// The cache artefact is likely downloaded again, e.g. by other CI jobs, so
+	// it's written to the local tier while it's served
+	populated := func(bool) {}
+	if tier != nil {
+		contents, populated = tier.tee(team.Bucket, team.ArtefactPath(artificateID), item, contents)
+	}
 
-	n, err := io.Copy(w, fileReference)
+	n, err := io.Copy(w, contents)
+	populated(err == nil)
+	if copySpan != nil {
+		copySpan.SetAttributes(attribute.Int64("storage.size", n))
+		endStorageSpan(copySpan, err)
+	}
 	if err != nil {
-		logger.Log("message", "error occurred while writing cache item to response", "error", err.Error())
-		logger.Log(err)
-		stdlog.Fatal(err)
+		// The client disconnected or the write timeout passed, the server
+		// keeps running
+		logger.Log("message", "error occurred while writing cache item to response", "artificateID", artificateID, "teamID", teamID, "error", err.Error())
+		return
 	}
 
+	recordCacheResult(r, teamID, cacheResultHit)
+	cacheDownloadedBytes.WithLabelValues(metricsTeam(r, teamID)).Add(float64(n))
+
 	logger.Log("message", fmt.Sprintf("total size of buffer=%d", n))
 }
 
+// headCacheItem answers whether an artefact exists without streaming its
+// contents, turbo uses this to probe the cache before downloading.
+func headCacheItem(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "headCacheItem()")
+	pathParams := mux.Vars(r)
+
+	artificateID, ok := pathParams["artificateId"]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !isSafePathSegment(artificateID) {
+		logger.Log("message", "rejecting the request for an invalid hash", "artificateID", artificateID)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if !query.Has("teamId") && !query.Has("slug") {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	teamID := getTeamID(query)
+	team, err := resolveTenant(teamID)
+	if err != nil {
+		logger.Log("message", "failed to resolve the bucket of the team", "teamID", teamID, "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	logger.Log("message", "received the following", "artificateID", artificateID, "teamID", teamID, "bucket", team.Bucket)
+
+	if !isCacheReadable(teamID) {
+		recordCacheResult(r, teamID, cacheResultMiss)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	item, err := readCacheBlob(r.Context(), artificateID, team)
+	if err != nil || item == nil {
+		logger.Log("message", "sending 404 as the cache item could not be found", "artificateID", artificateID)
+		recordCacheResult(r, teamID, cacheResultMiss)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	size, err := item.Size()
+	if err != nil {
+		logger.Log("message", "failed to determine size of cache item", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if etag, err := item.ETag(); err == nil && etag != "" {
+		w.Header().Set("ETag", quoteETag(etag))
+	}
+	setArtifactHeaders(w, item)
+	recordCacheResult(r, teamID, cacheResultHit)
+	w.WriteHeader(http.StatusOK)
+}
+
+// getArtifactMetadata returns the metadata to store together with the cache
+// artefact based on the headers turbo sends along with the upload.
+func getArtifactMetadata(r *http.Request) map[string]interface{} {
+	metadata := make(map[string]interface{})
+
+	if duration := r.Header.Get("x-artifact-duration"); duration != "" {
+		metadata[metadataArtifactDuration] = duration
+	}
+
+	if tag := r.Header.Get("x-artifact-tag"); tag != "" {
+		metadata[metadataArtifactTag] = tag
+	}
+
+	return metadata
+}
+
+// setArtifactHeaders returns the headers that were received while uploading
+// the cache artefact, so turbo can verify signatures and report time saved.
+func setArtifactHeaders(w http.ResponseWriter, item stow.Item) {
+	if duration := getItemMetadata(item, metadataArtifactDuration); duration != "" {
+		w.Header().Set("x-artifact-duration", duration)
+	}
+
+	if tag := getItemMetadata(item, metadataArtifactTag); tag != "" {
+		w.Header().Set("x-artifact-tag", tag)
+	}
+}
+
+// getItemMetadata returns the string value of the given metadata key of the
+// item, or an empty string when the backend doesn't know about the key.
+func getItemMetadata(item stow.Item, key string) string {
+	itemMetadata, err := item.Metadata()
+	if err != nil {
+		return ""
+	}
+
+	value, ok := itemMetadata[key].(string)
+	if !ok {
+		return ""
+	}
+
+	return value
+}
+
+// quoteETag wraps the etag in double quotes as required by RFC 7232, some
+// backends already return the etag quoted.
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+
+	return strconv.Quote(etag)
+}
+
 func writeCacheItem(w http.ResponseWriter, r *http.Request) {
 	logger.Log("message", "writeCacheItem()")
 	pathParams := mux.Vars(r)
@@ -446,6 +702,14 @@ func writeCacheItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The hash is used as path of the cache artefact, so it must not refer to
+	// the cache artefacts of another team
+	if !isSafePathSegment(artificateID) {
+		logger.Log("message", "rejecting the request for an invalid hash", "artificateID", artificateID)
+		writeInvalidHashError(w, artificateID)
+		return
+	}
+
 	query := r.URL.Query()
 	if !query.Has("teamId") && !query.Has("slug") {
 		w.WriteHeader(http.StatusPreconditionFailed)
@@ -454,50 +718,154 @@ func writeCacheItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If teamId and slug are defined, we use slug over teamId
-	teamID := query.Get("teamId")
-	if query.Has("slug") {
-		teamID = query.Get("slug")
+	teamID := getTeamID(query)
+	team, err := resolveTenant(teamID)
+	if err != nil {
+		logger.Log("message", "failed to resolve the bucket of the team", "teamID", teamID, "error", err)
+		writeInvalidTeamError(w, err)
+		return
+	}
+	logger.Log("message", "received the following", "teamID", teamID, "bucket", team.Bucket)
+
+	// A team over its quota can still replace a cache artefact, the quota
+	// decides whether the upload fits
+	if status := getCacheStatus(teamID); status != cacheStatusEnabled && status != cacheStatusOverLimit {
+		logger.Log("message", "refusing to store cache item as remote caching is not enabled for the team", "teamID", teamID, "status", status)
+		writeCacheStatusError(w, status)
+		return
+	}
+
+	if r.ContentLength > artifactSizeLimit() {
+		logger.Log("message", "rejecting cache item exceeding the maximum size", "artificateID", artificateID, "size", r.ContentLength)
+		writeArtifactTooLarge(w)
+		return
+	}
+
+	body := newLimitedReader(r.Body, artifactSizeLimit())
+	var contents io.Reader = body
+	contentLength := r.ContentLength
+
+	// Verify the signature of the cache artefact before storing it
+	var signature gohash.Hash
+	tag := r.Header.Get("x-artifact-tag")
+	if *enableSignatureVerification {
+		// The secret is looked up by the team turbo signed the cache
+		// artefact with, which can differ from the slug the bucket is
+		// resolved with
+		signingTeamID := signatureTeamID(query)
+		key, ok := getSignatureKey(signingTeamID)
+		if !ok {
+			logger.Log("message", "no signature key configured for team", "teamID", signingTeamID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"message":"no signature key configured for team","code":"forbidden"}}`))
+			return
+		}
+
+		if tag == "" {
+			logger.Log("message", "rejecting unsigned cache item", "artificateID", artificateID, "teamID", teamID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"the x-artifact-tag header with the signature of the artifact is missing","code":"missing_signature"}}`))
+			return
+		}
+
+		signature, err = newArtifactSignature(key, artificateID, signingTeamID)
+		if err != nil {
+			logger.Log("message", "failed to calculate the signature of the cache item", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"failed to verify the signature of the artifact","code":"internal_error"}}`))
+			return
+		}
+	}
+
+	// The request body is stored in a temporary file when the signature needs
+	// to be verified before storing the cache artefact, or when the size of the
+	// body is unknown and the storage provider or the quota can't handle that.
+	if signature != nil || (contentLength < 0 && (!streamingKinds[*kind] || getQuota(teamID) > 0)) {
+		var hashWriter io.Writer = ioutil.Discard
+		if signature != nil {
+			hashWriter = signature
+		}
+
+		upload, err := spoolUpload(body, hashWriter)
+		if err != nil {
+			if body.exceeded {
+				logger.Log("message", "rejecting cache item exceeding the maximum size", "artificateID", artificateID)
+				writeArtifactTooLarge(w)
+				return
+			}
+
+			logger.Log("message", "error occurred while reading the request body", "error", err.Error())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"failed to read request body","code":"bad_request"}}`))
+			return
+		}
+		defer upload.Close()
+
+		if signature != nil && !verifyArtifactSignature(signature, tag) {
+			logger.Log("message", "rejecting cache item with invalid signature", "artificateID", artificateID, "teamID", teamID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"message":"the signature of the artifact is invalid","code":"invalid_signature"}}`))
+			return
+		}
+
+		contents = upload
+		contentLength = upload.size
 	}
-	sanitisedteamID := GetBucketName(teamID)
-	logger.Log("message", "received the following", "teamID", teamID, "sanitisedteamID", sanitisedteamID)
 
-	_, path, err := createCacheBlob(artificateID, sanitisedteamID, r.Body, r.ContentLength)
+	release, err := quotas.Reserve(team, team.ArtefactPath(artificateID), contentLength)
 	if err != nil {
+		if err == errQuotaExceeded {
+			logger.Log("message", "rejecting cache item exceeding the storage quota of the team", "artificateID", artificateID, "teamID", teamID, "size", contentLength)
+			if quotas.IsOverLimit(team) {
+				writeCacheStatusError(w, cacheStatusOverLimit)
+				return
+			}
+			writeQuotaExceeded(w)
+			return
+		}
+
+		logger.Log("message", "failed to determine the storage usage of the team", "teamID", teamID, "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"message":"failed to determine the storage usage of the team","code":"internal_error"}}`))
+		return
+	}
+
+	_, path, err := createCacheBlob(ctx, artificateID, team, contents, contentLength, getArtifactMetadata(r))
+	release(err == nil)
+	if err != nil {
+		if body.exceeded {
+			logger.Log("message", "rejecting cache item exceeding the maximum size", "artificateID", artificateID)
+			writeArtifactTooLarge(w)
+			return
+		}
+
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(fmt.Sprintf(`{"error":{"message":"failed to save cache item with id %s","code":"internal_error"}}`, artificateID)))
 		return
 	}
 
+	cacheUploadedBytes.WithLabelValues(metricsTeam(r, teamID)).Add(float64(artifactSizeLimit() - body.remaining))
+
 	w.WriteHeader(http.StatusAccepted)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(fmt.Sprintf(`{"urls": ["%s"]}`, path)))
 }
 
-func initTracer() *sdktrace.TracerProvider {
-	// Create stdout exporter to be able to retrieve
-	// the collected spans.
-	_, err := stdout.New(stdout.WithPrettyPrint())
-	if err != nil {
-		stdlog.Fatal(err)
-	}
-
-	// For the demonstration, use sdktrace.AlwaysSample sampler to sample all traces.
-	// In a production application, use sdktrace.ProbabilitySampler with a desired probability.
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		//sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("tapico-remote-cache-service"))),
-	)
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
-	return tp
-}
-
 func main() {
-	kingpin.Version("0.0.1")
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	app.Version(getVersion())
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	if command == hashTokenCommand.FullCommand() {
+		app.FatalIfError(runHashToken(), "failed to hash the token")
+		return
+	}
 
 	fmt.Printf("projectID: %s kind: %s localStoragePath: %s aws.endpoint: %s google.endpoint: %s google.credentialsJsonPath: %s", *googleProjectID, *kind, *localStoragePath, *awsEndpoint, *googleEndpoint, *googleCredentialsJSON)
 
@@ -509,46 +877,178 @@ func main() {
 	// call for debugging in the future.
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "loc", log.DefaultCaller)
 
-	tp := initTracer()
+	tp, err := initTracer()
+	if err != nil {
+		logger.Log("message", "failed to initialise the tracer", "error", err)
+		os.Exit(1)
+	}
+	// Flush the spans that haven't been exported yet before exiting
 	defer func() {
-		if err := tp.Shutdown(context.Background()); err != nil {
-			logger.Log("message", "Error shutting down tracer provider: %v", err)
+		ctx, cancel := context.WithTimeout(context.Background(), *serverShutdownTimeout)
+		defer cancel()
+
+		if err := tp.Shutdown(ctx); err != nil {
+			logger.Log("message", "failed to shut down the tracer provider", "error", err)
 		}
 	}()
 
+	if err := validateTeamCacheStatus(); err != nil {
+		logger.Log("message", "invalid --team-cache-status argument", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initTenancy(); err != nil {
+		logger.Log("message", "invalid --bucket-template argument", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initLocalTier(); err != nil {
+		logger.Log("message", "failed to initialise the local tier", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initAccounts(); err != nil {
+		logger.Log("message", "failed to load the accounts configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initRetention(); err != nil {
+		logger.Log("message", "invalid --retention.team-max-age argument", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initQuotas(); err != nil {
+		logger.Log("message", "invalid --quota.team-max-size argument", "error", err)
+		os.Exit(1)
+	}
+
+	if command == gcCommand.FullCommand() {
+		err := runGarbageCollection()
+		closeLocation()
+		if err != nil {
+			logger.Log("message", "failed to remove expired cache items", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := initTokens(); err != nil {
+		logger.Log("message", "failed to load the tokens passed via --tokens.file", "error", err)
+		os.Exit(1)
+	}
+
+	// Clients can authenticate with a client certificate or a JWT instead of
+	// a token
+	if *allowedTurboTokens == "" && *tokensFile == "" && !isClientCertAuthEnabled() && !isOIDCEnabled() {
+		logger.Log("message", "the --turbo-token or --tokens.file argument is required to start the server")
+		os.Exit(1)
+	}
+
+	stopWatchingTokens := watchTokens()
+	defer stopWatchingTokens()
+
+	stopOIDC, err := initOIDC()
+	if err != nil {
+		logger.Log("message", "failed to initialise the OIDC authentication", "error", err)
+		os.Exit(1)
+	}
+	defer stopOIDC()
+
+	stopAuthFailures, err := initAuthFailures()
+	if err != nil {
+		logger.Log("message", "failed to initialise the limit of failed authentications", "error", err)
+		os.Exit(1)
+	}
+	defer stopAuthFailures()
+
+	stopRateLimits, err := initRateLimits()
+	if err != nil {
+		logger.Log("message", "failed to initialise the rate limits", "error", err)
+		os.Exit(1)
+	}
+	defer stopRateLimits()
+
+	tlsConfig, stopTLS, err := initTLS()
+	if err != nil {
+		logger.Log("message", "failed to initialise TLS", "error", err)
+		os.Exit(1)
+	}
+	defer stopTLS()
+
+	closeEventSink, err := initEventSink()
+	if err != nil {
+		logger.Log("message", "failed to initialise the cache event sink", "error", err)
+		os.Exit(1)
+	}
+	defer closeEventSink()
+	defer closeLocation()
+
+	closeAccessIndex, err := initAccessIndex()
+	if err != nil {
+		logger.Log("message", "failed to open the index of access times", "error", err)
+		os.Exit(1)
+	}
+	defer closeAccessIndex()
+
+	stopRetentionSweeper := startRetentionSweeper()
+	defer stopRetentionSweeper()
+
 	loggingMiddleware := LoggingMiddleware(logger)
 	tokenMiddleware := TokenMiddleware(logger)
 
 	r := mux.NewRouter()
 	r.Use(otelmux.Middleware("tapico-remote-cache"))
-	r.Use(tokenMiddleware)
+
+	// The pages opened in the browser by `turbo login`
+	login := r.PathPrefix("/turborepo").Subrouter()
+	login.HandleFunc("/token", showLogin).Methods(http.MethodGet)
+	login.HandleFunc("/token", submitLogin).Methods(http.MethodPost)
+	login.HandleFunc("/success", showLoginSuccess).Methods(http.MethodGet)
+
+	v2 := r.PathPrefix("/v2").Subrouter()
+	v2.Use(tokenMiddleware)
+	v2.HandleFunc("/user", readUser).Methods(http.MethodGet)
+	v2.HandleFunc("/teams", readTeams).Methods(http.MethodGet)
+	v2.HandleFunc("/teams/{teamId}", readTeam).Methods(http.MethodGet)
 
 	// https://api.vercel.com/v8/artifacts/09b4848294e347d8?teamID=team_lMDgmODIeVfSbCQNQPDkX8cF
 	api := r.PathPrefix("/v8").Subrouter()
+	api.Use(tokenMiddleware, RateLimitMiddleware)
+	api.HandleFunc("/artifacts", queryCacheItems).Methods(http.MethodPost)
+	api.HandleFunc("/artifacts/events", recordCacheEvents).Methods(http.MethodPost)
+	api.HandleFunc("/artifacts/events", readCacheEvents).Methods(http.MethodGet)
+	api.HandleFunc("/artifacts/status", readCacheStatus).Methods(http.MethodGet)
 	api.HandleFunc("/artifacts/{artificateId}", readCacheItem).Methods(http.MethodGet)
+	api.HandleFunc("/artifacts/{artificateId}", headCacheItem).Methods(http.MethodHead)
 	api.HandleFunc("/artifacts/{artificateId}", writeCacheItem).Methods(http.MethodPost)
 	api.HandleFunc("/artifacts/{artificateId}", writeCacheItem).Methods(http.MethodPut)
-	http.Handle("/", r)
 
-	loggedRouter := loggingMiddleware(r)
+	// Prometheus metrics, unlike the other monitoring endpoints this can
+	// require a token via --metrics.token
+	r.Handle("/metrics", MetricsAuthMiddleware(promhttp.Handler())).Methods(http.MethodGet)
+
+	// The probes of Kubernetes and Cloud Run
+	r.HandleFunc("/healthz", readHealth).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/readyz", readReadiness).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/version", readVersion).Methods(http.MethodGet)
+	http.Handle("/", r)
 
-	print("Starting the Tapico Turborepo remote cache server")
+	loggedRouter := loggingMiddleware(MetricsMiddleware(r)(r))
 
 	// Start server
 	address := os.Getenv("LISTEN_ADDRESS")
-	if len(address) > 0 {
-		err := http.ListenAndServe(address, loggedRouter)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("Started tapico-turborepo-remote-cache server at %s", address)
-	} else {
+	if len(address) == 0 {
 		// Default port 8080
-		err := http.ListenAndServe("localhost:8080", loggedRouter)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("Started tapico-turborepo-remote-cache server at %s", "localhost:8080")
+		address = "localhost:8080"
+	}
+
+	server := newServer(address, loggedRouter)
+	server.TLSConfig = tlsConfig
+
+	logger.Log("message", "starting the Tapico Turborepo remote cache server", "address", address, "tls", tlsConfig != nil)
+	if err := runServer(server); err != nil {
+		logger.Log("message", "failed to start the server", "address", address, "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -564,48 +1064,88 @@ func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
 	return &responseWriter{ResponseWriter: w}
 }
 
-func isElementExist(s []string, str string) bool {
-	for _, v := range s {
-		if v == str {
-			return true
-		}
+// Status returns the written HTTP status code, handlers that only write the
+// body respond with 200 OK.
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
 	}
-	return false
+	rw.ResponseWriter.WriteHeader(code)
 }
 
 func TokenMiddleware(logger log.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(res http.ResponseWriter, req *http.Request) {
-			logger.Log("message", "checking if received token is in the list of accepted tokens", "tokens", *allowedTurboTokens)
+			// Clients that failed to authenticate too often are rejected before
+			// the token is verified
+			if delay := authFailureDelay(req); delay > 0 {
+				writeTooManyAuthFailures(res, delay)
+				return
+			}
 
-			// get token from authentication header
-			var isAccepted = false
+			// get token from authentication header, the token and the accepted
+			// tokens are never logged
+			var who *principal
 
 			authorizationHeader := req.Header.Get("Authorization")
 			if authorizationHeader != "" {
-				logger.Log("message", "get auth header", authorizationHeader)
-
 				// Split up the Authorization header by space to get the part of Bearer
 				parts := strings.Split(authorizationHeader, "Bearer")
-				logger.Log("authHeaderParts", strings.Join(parts, ","))
 				if len(parts) == 2 {
 					token := strings.TrimSpace(parts[1])
-					logger.Log("token", token)
-
-					allowedTokensList := strings.Split(*allowedTurboTokens, ",")
 
-					if isElementExist(allowedTokensList, token) {
-						isAccepted = true
+					if token == "" {
+						logger.Log("message", "received an empty token")
+					} else if isAllowedTurboToken(token) {
+						who = fullAccess("turbo-token")
+					} else if isJWT(token) {
+						// JWTs are never looked up in the token file, as
+						// verifying the argon2id hashes is slow
+						if who, _ = verifyJWT(token); who == nil {
+							recordAuthFailure(req)
+						}
+					} else if grant, ok := lookupToken(token); ok {
+						who = grant
+					} else if id, ok := parseLoginToken(token, time.Now()); ok {
+						who = loginPrincipal(id)
 					} else {
-						logger.Log("message", "the token passed via --turbo-token is missing the received token", "receivedToken", token, "allowedTokens", *allowedTurboTokens)
+						logger.Log("message", "received a token that is not accepted", "remoteAddr", req.RemoteAddr)
+						recordAuthFailure(req)
+					}
+
+					if who != nil {
+						who = who.withID(token)
 					}
 				}
 			}
 
-			// if iAccepted is true we run the next http handler,  if not we return a 403
-			if isAccepted {
-				logger.Log("message", "TURBO_TOKEN token found in allowance token list")
-				next.ServeHTTP(res, req)
+			// A client certificate can replace or is required next to the token
+			who = authenticateClientCert(req, who)
+
+			// if who is known we run the next http handler,  if not we return a 401
+			if who != nil {
+				logger.Log("message", "TURBO_TOKEN token found in allowance token list", "principal", who.Name)
+
+				// The token may be limited to some teams or to reading
+				if !isAuthorized(req, who) {
+					logger.Log("message", "the token has no permission for the request", "principal", who.Name, "method", req.Method, "path", req.URL.Path)
+					writeJSON(res, http.StatusForbidden, map[string]interface{}{
+						"error": map[string]string{
+							"message": "the given TURBO_TOKEN has no permission to access the team or to upload artifacts",
+							"code":    "forbidden",
+						},
+					})
+					return
+				}
+
+				next.ServeHTTP(res, req.WithContext(withPrincipal(req.Context(), who)))
 			} else {
 				logger.Log("message", "missing TURBO_TOKEN")
 				res.WriteHeader(http.StatusUnauthorized)
@@ -637,7 +1177,7 @@ func LoggingMiddleware(logger log.Logger) func(http.Handler) http.Handler {
 			wrapped := wrapResponseWriter(w)
 			next.ServeHTTP(wrapped, r)
 			logger.Log(
-				"status", wrapped.status,
+				"status", wrapped.Status(),
 				"method", r.Method,
 				"path", r.URL.EscapedPath(),
 				"duration", time.Since(start),
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"container/list"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/graymeta/stow"
+)
+
+// Kind represents the name of the location/storage type.
+const Kind = "memory"
+
+const (
+	// The maximum number of bytes of all the items stored in the location, the
+	// least recently used items are removed when exceeded. A value of 0 (the
+	// default) disables the limit.
+	ConfigMaxBytes = "max_bytes"
+)
+
+func init() {
+	validatefn := func(config stow.Config) error {
+		_, err := parseMaxBytes(config)
+		return err
+	}
+	makefn := func(config stow.Config) (stow.Location, error) {
+		maxBytes, err := parseMaxBytes(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Location{
+			config:     config,
+			maxBytes:   maxBytes,
+			containers: make(map[string]*Container),
+			lru:        list.New(),
+		}, nil
+	}
+
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == Kind
+	}
+
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
+
+func parseMaxBytes(config stow.Config) (int64, error) {
+	value, ok := config.Config(ConfigMaxBytes)
+	if !ok || value == "" {
+		return 0, nil
+	}
+
+	maxBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxBytes < 0 {
+		return 0, errors.New("max bytes must be a positive number")
+	}
+	return maxBytes, nil
+}
@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"container/list"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/graymeta/stow"
+)
+
+type Container struct {
+	// Name of the container.
+	name string
+
+	// location holds the lock that guards the items of the container.
+	location *Location
+
+	// items are the elements of the least recently used list of the location.
+	items map[string]*list.Element
+}
+
+// ID returns the name of the container.
+func (c *Container) ID() string {
+	return c.name
+}
+
+// Name returns the name of the container.
+func (c *Container) Name() string {
+	return c.name
+}
+
+// Item returns the item with the given name, the item is marked as recently
+// used.
+func (c *Container) Item(id string) (stow.Item, error) {
+	c.location.mu.Lock()
+	defer c.location.mu.Unlock()
+
+	element, ok := c.items[id]
+	if !ok {
+		return nil, stow.ErrNotFound
+	}
+
+	c.location.lru.MoveToFront(element)
+	return element.Value.(*Item), nil
+}
+
+// Items returns a list of items that are prefixed with the prefix argument,
+// the cursor is the name of the last returned item.
+func (c *Container) Items(prefix string, cursor string, count int) ([]stow.Item, string, error) {
+	c.location.mu.Lock()
+	defer c.location.mu.Unlock()
+
+	names := make([]string, 0, len(c.items))
+	for name := range c.items {
+		names = append(names, name)
+	}
+
+	page, next := paginate(names, prefix, cursor, count)
+
+	items := make([]stow.Item, len(page))
+	for i, name := range page {
+		items[i] = c.items[name].Value.(*Item)
+	}
+	return items, next, nil
+}
+
+// Put reads the contents into memory and stores them as a new item, an
+// existing item with the same name is replaced. A size of -1 means the size
+// is unknown.
+func (c *Container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	maxBytes := c.location.maxBytes
+
+	if maxBytes > 0 {
+		if size > maxBytes {
+			return nil, fmt.Errorf("item of %d bytes exceeds the maximum of %d bytes", size, maxBytes)
+		}
+
+		// Read one byte more than allowed, to detect the limit being exceeded
+		r = io.LimitReader(r, maxBytes+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("item exceeds the maximum of %d bytes", maxBytes)
+	}
+
+	if size >= 0 && int64(len(data)) != size {
+		return nil, fmt.Errorf("expected %d bytes but read %d bytes", size, len(data))
+	}
+
+	md := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf(`value of key '%s' in metadata must be of type string`, key)
+		}
+		md[key] = str
+	}
+
+	hash := md5.Sum(data)
+	item := &Item{
+		name:      name,
+		container: c,
+		data:      data,
+		size:      int64(len(data)),
+		metadata:  md,
+		etag:      hex.EncodeToString(hash[:]),
+		lastMod:   time.Now(),
+	}
+
+	c.location.mu.Lock()
+	defer c.location.mu.Unlock()
+
+	c.location.add(c, item)
+	return item, nil
+}
+
+// RemoveItem removes the item with the given name.
+func (c *Container) RemoveItem(id string) error {
+	c.location.mu.Lock()
+	defer c.location.mu.Unlock()
+
+	if !c.location.remove(c, id) {
+		return stow.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"time"
+)
+
+// Item is an immutable item stored in memory, storing an item with the same
+// name creates a new item instead of changing the existing one.
+type Item struct {
+	name      string
+	container *Container
+	data      []byte
+	size      int64
+	metadata  map[string]interface{}
+	etag      string
+	lastMod   time.Time
+}
+
+// ID returns the name of the item.
+func (i *Item) ID() string {
+	return i.name
+}
+
+// Name returns the name of the item.
+func (i *Item) Name() string {
+	return i.name
+}
+
+// URL returns the URL of the item in the form of memory://container/item.
+func (i *Item) URL() *url.URL {
+	return &url.URL{
+		Scheme: Kind,
+		Host:   i.container.name,
+		Path:   "/" + i.name,
+	}
+}
+
+// Size returns the size of the item in bytes.
+func (i *Item) Size() (int64, error) {
+	return i.size, nil
+}
+
+// Open returns a reader of the contents of the item.
+func (i *Item) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(i.data)), nil
+}
+
+// ETag returns the MD5 hash of the contents of the item.
+func (i *Item) ETag() (string, error) {
+	return i.etag, nil
+}
+
+// LastMod returns the time the item was stored.
+func (i *Item) LastMod() (time.Time, error) {
+	return i.lastMod, nil
+}
+
+// Metadata returns a copy of the metadata of the item.
+func (i *Item) Metadata() (map[string]interface{}, error) {
+	metadata := make(map[string]interface{}, len(i.metadata))
+	for key, value := range i.metadata {
+		metadata[key] = value
+	}
+	return metadata, nil
+}
@@ -0,0 +1,169 @@
+package memory
+
+import (
+	"container/list"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/graymeta/stow"
+)
+
+// Location keeps all the containers and items in memory, the items of all the
+// containers share a single least recently used list to stay within the
+// maximum number of bytes.
+type Location struct {
+	config   stow.Config
+	maxBytes int64
+
+	mu         sync.Mutex
+	size       int64
+	containers map[string]*Container
+	lru        *list.List
+}
+
+// Close removes all the containers and items.
+func (l *Location) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.containers = make(map[string]*Container)
+	l.lru.Init()
+	l.size = 0
+	return nil
+}
+
+// CreateContainer creates a new container, the existing container is returned
+// when a container with the same name already exists.
+func (l *Location) CreateContainer(name string) (stow.Container, error) {
+	if name == "" {
+		return nil, errors.New("container name can't be empty")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if container, ok := l.containers[name]; ok {
+		return container, nil
+	}
+
+	container := &Container{
+		name:     name,
+		location: l,
+		items:    make(map[string]*list.Element),
+	}
+	l.containers[name] = container
+	return container, nil
+}
+
+// Containers returns a list of containers that are prefixed with the prefix
+// argument, the cursor is the name of the last returned container.
+func (l *Location) Containers(prefix string, cursor string, count int) ([]stow.Container, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := make([]string, 0, len(l.containers))
+	for name := range l.containers {
+		names = append(names, name)
+	}
+
+	page, next := paginate(names, prefix, cursor, count)
+
+	containers := make([]stow.Container, len(page))
+	for i, name := range page {
+		containers[i] = l.containers[name]
+	}
+	return containers, next, nil
+}
+
+// Container returns the container with the given name.
+func (l *Location) Container(id string) (stow.Container, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	container, ok := l.containers[id]
+	if !ok {
+		return nil, stow.ErrNotFound
+	}
+	return container, nil
+}
+
+// RemoveContainer removes the container together with its items.
+func (l *Location) RemoveContainer(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	container, ok := l.containers[id]
+	if !ok {
+		return stow.ErrNotFound
+	}
+
+	for name := range container.items {
+		l.remove(container, name)
+	}
+	delete(l.containers, id)
+	return nil
+}
+
+// ItemByURL returns the item for a URL in the form of memory://container/item.
+func (l *Location) ItemByURL(u *url.URL) (stow.Item, error) {
+	if u.Scheme != Kind {
+		return nil, errors.New("not valid memory URL")
+	}
+
+	container, err := l.Container(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return container.Item(strings.TrimPrefix(u.Path, "/"))
+}
+
+// add stores the item as the most recently used item and removes the least
+// recently used items when the maximum number of bytes is exceeded, the caller
+// must hold the lock.
+func (l *Location) add(container *Container, item *Item) {
+	l.remove(container, item.name)
+
+	container.items[item.name] = l.lru.PushFront(item)
+	l.size += item.size
+
+	for l.maxBytes > 0 && l.size > l.maxBytes && l.lru.Len() > 1 {
+		oldest := l.lru.Back().Value.(*Item)
+		l.remove(oldest.container, oldest.name)
+	}
+}
+
+// remove forgets about the item, the caller must hold the lock.
+func (l *Location) remove(container *Container, name string) bool {
+	element, ok := container.items[name]
+	if !ok {
+		return false
+	}
+
+	l.size -= element.Value.(*Item).size
+	l.lru.Remove(element)
+	delete(container.items, name)
+	return true
+}
+
+// paginate returns the sorted names with the given prefix that come after the
+// cursor, together with the cursor of the next page.
+func paginate(names []string, prefix string, cursor string, count int) ([]string, string) {
+	sort.Strings(names)
+
+	var page []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || (cursor != stow.CursorStart && name <= cursor) {
+			continue
+		}
+
+		if count > 0 && len(page) == count {
+			return page, page[len(page)-1]
+		}
+		page = append(page, name)
+	}
+
+	return page, ""
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/graymeta/stow"
+)
+
+func TestLeastRecentlyUsedEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		// steps are put <container>/<name> <size> or get <container>/<name>
+		steps []string
+		want  []string
+	}{
+		{
+			name:     "within the limit",
+			maxBytes: 10,
+			steps:    []string{"put a/1 4", "put a/2 4"},
+			want:     []string{"a/1", "a/2"},
+		},
+		{
+			name:     "oldest is evicted",
+			maxBytes: 10,
+			steps:    []string{"put a/1 4", "put a/2 4", "put a/3 4"},
+			want:     []string{"a/2", "a/3"},
+		},
+		{
+			name:     "read marks as recently used",
+			maxBytes: 10,
+			steps:    []string{"put a/1 4", "put a/2 4", "get a/1", "put a/3 4"},
+			want:     []string{"a/1", "a/3"},
+		},
+		{
+			name:     "shared between containers",
+			maxBytes: 10,
+			steps:    []string{"put a/1 4", "put b/1 4", "put b/2 4"},
+			want:     []string{"b/1", "b/2"},
+		},
+		{
+			name:     "replacing counts the new size only",
+			maxBytes: 10,
+			steps:    []string{"put a/1 4", "put a/2 4", "put a/1 6"},
+			want:     []string{"a/1", "a/2"},
+		},
+		{
+			name:     "several are evicted for a large item",
+			maxBytes: 10,
+			steps:    []string{"put a/1 3", "put a/2 3", "put a/3 3", "put a/4 9"},
+			want:     []string{"a/4"},
+		},
+		{
+			name:     "no limit",
+			maxBytes: 0,
+			steps:    []string{"put a/1 400", "put a/2 400", "put a/3 400"},
+			want:     []string{"a/1", "a/2", "a/3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location, err := stow.Dial(Kind, stow.ConfigMap{ConfigMaxBytes: strconv.FormatInt(test.maxBytes, 10)})
+			if err != nil {
+				t.Fatalf("stow.Dial() failed: %v", err)
+			}
+			defer location.Close()
+
+			for _, step := range test.steps {
+				fields := strings.Fields(step)
+				parts := strings.SplitN(fields[1], "/", 2)
+
+				container, err := location.CreateContainer(parts[0])
+				if err != nil {
+					t.Fatalf("CreateContainer() failed: %v", err)
+				}
+
+				switch fields[0] {
+				case "put":
+					size, _ := strconv.Atoi(fields[2])
+					if _, err := container.Put(parts[1], strings.NewReader(strings.Repeat("x", size)), int64(size), nil); err != nil {
+						t.Fatalf("%s failed: %v", step, err)
+					}
+				case "get":
+					if _, err := container.Item(parts[1]); err != nil {
+						t.Fatalf("%s failed: %v", step, err)
+					}
+				}
+			}
+
+			var stored []string
+			containers, _, err := location.Containers("", stow.CursorStart, 100)
+			if err != nil {
+				t.Fatalf("Containers() failed: %v", err)
+			}
+			for _, container := range containers {
+				items, _, err := container.Items("", stow.CursorStart, 100)
+				if err != nil {
+					t.Fatalf("Items() failed: %v", err)
+				}
+				for _, item := range items {
+					stored = append(stored, container.Name()+"/"+item.Name())
+				}
+			}
+			sort.Strings(stored)
+
+			if strings.Join(stored, ",") != strings.Join(test.want, ",") {
+				t.Errorf("stored %v, want %v", stored, test.want)
+			}
+		})
+	}
+}
+
+func TestPutExceedingMaxBytes(t *testing.T) {
+	location, err := stow.Dial(Kind, stow.ConfigMap{ConfigMaxBytes: "4"})
+	if err != nil {
+		t.Fatalf("stow.Dial() failed: %v", err)
+	}
+	defer location.Close()
+
+	container, err := location.CreateContainer("a")
+	if err != nil {
+		t.Fatalf("CreateContainer() failed: %v", err)
+	}
+
+	// An item of unknown size is only rejected after reading it
+	for _, size := range []int64{5, -1} {
+		if _, err := container.Put("1", strings.NewReader("xxxxx"), size, nil); err == nil {
+			t.Errorf("Put() of 5 bytes with size %d succeeded, want an error", size)
+		}
+	}
+	if _, err := container.Item("1"); err != stow.ErrNotFound {
+		t.Errorf("Item() returned %v, want stow.ErrNotFound", err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/graymeta/stow"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "tapico_remote_cache"
+
+var metricsToken = app.Flag(
+	"metrics.token", "The token Prometheus has to send to scrape /metrics, by default no token is required ($TURBO_METRICS_TOKEN).",
+).Envar("TURBO_METRICS_TOKEN").String()
+
+// metricsTeamUnknown is the team label of the teams that aren't configured, as
+// tokens with access to all teams can send any team id, which would create an
+// unbounded number of series.
+const metricsTeamUnknown = "unknown"
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "The number of handled HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "The duration of handling HTTP requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_in_flight",
+		Help:      "The number of HTTP requests that are currently handled.",
+	})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "The number of cache artefact lookups by team, method and result (hit or miss).",
+	}, []string{"team", "method", "result"})
+
+	cacheUploadedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_uploaded_bytes_total",
+		Help:      "The number of bytes of stored cache artefacts by team.",
+	}, []string{"team"})
+
+	cacheDownloadedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_downloaded_bytes_total",
+		Help:      "The number of bytes of served cache artefacts by team.",
+	}, []string{"team"})
+
+	storageOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "The duration of the operations on the storage provider by kind and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind", "operation"})
+
+	storageOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_operation_errors_total",
+		Help:      "The number of failed operations on the storage provider by kind and operation.",
+	}, []string{"kind", "operation"})
+
+	rateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "The number of requests rejected by the rate limits by limit (reads or writes).",
+	}, []string{"limit"})
+
+	rejectedAuthentications = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rejected_authentications_total",
+		Help:      "The number of requests rejected without checking the token, as the client failed to authenticate too often.",
+	})
+)
+
+const (
+	cacheResultHit  = "hit"
+	cacheResultMiss = "miss"
+)
+
+// metricsTeam returns the team label of the request, only the teams defined
+// via --accounts.config and the teams the principal was explicitly given
+// access to are labelled.
+func metricsTeam(r *http.Request, teamID string) string {
+	teamID = canonicalTeamID(teamID)
+
+	if configuredAccounts != nil {
+		if _, ok := configuredAccounts.findTeam(teamID); ok {
+			return teamID
+		}
+	}
+
+	if who := getPrincipal(r.Context()); who != nil {
+		for _, allowed := range who.Teams {
+			if allowed != "*" && canonicalTeamID(allowed) == teamID {
+				return teamID
+			}
+		}
+	}
+
+	return metricsTeamUnknown
+}
+
+// recordCacheResult counts a lookup of a cache artefact by the team.
+func recordCacheResult(r *http.Request, teamID string, result string) {
+	cacheRequestsTotal.WithLabelValues(metricsTeam(r, teamID), r.Method, result).Inc()
+}
+
+// MetricsAuthMiddleware requires the token passed via --metrics.token to
+// scrape the metrics, as the team labels reveal the names of the teams.
+func MetricsAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *metricsToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			digest, expected := sha256.Sum256([]byte(token)), sha256.Sum256([]byte(*metricsToken))
+			if subtle.ConstantTimeCompare(digest[:], expected[:]) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "a valid metrics token is required", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MetricsMiddleware records the number, duration and status of the requests
+// handled by the router, the route is the path template of the matching
+// route so the number of series stays bounded.
+func MetricsMiddleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			route := "unmatched"
+			var match mux.RouteMatch
+			if router.Match(r, &match) && match.Route != nil {
+				if template, err := match.Route.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+
+			httpRequestsInFlight.Inc()
+			defer httpRequestsInFlight.Dec()
+
+			start := time.Now()
+			wrapped := wrapResponseWriter(w)
+			next.ServeHTTP(wrapped, r)
+
+			status := strconv.Itoa(wrapped.Status())
+			httpRequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+			httpRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// observeStorageOperation records the duration of an operation on the storage
+// provider, an item that isn't found is not counted as an error.
+func observeStorageOperation(operation string, start time.Time, err error) {
+	storageOperationDuration.WithLabelValues(*kind, operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != stow.ErrNotFound {
+		storageOperationErrors.WithLabelValues(*kind, operation).Inc()
+	}
+}
+
+// instrumentedContainer records the operations on a container of the storage
+// provider.
+type instrumentedContainer struct {
+	stow.Container
+}
+
+func instrumentContainer(container stow.Container) stow.Container {
+	return &instrumentedContainer{Container: container}
+}
+
+func (c *instrumentedContainer) Item(id string) (stow.Item, error) {
+	start := time.Now()
+	item, err := c.Container.Item(id)
+	observeStorageOperation("item", start, err)
+	return item, err
+}
+
+func (c *instrumentedContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	start := time.Now()
+	items, next, err := c.Container.Items(prefix, cursor, count)
+	observeStorageOperation("items", start, err)
+	return items, next, err
+}
+
+func (c *instrumentedContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	start := time.Now()
+	item, err := c.Container.Put(name, r, size, metadata)
+	observeStorageOperation("put", start, err)
+	return item, err
+}
+
+func (c *instrumentedContainer) RemoveItem(id string) error {
+	start := time.Now()
+	err := c.Container.RemoveItem(id)
+	observeStorageOperation("remove", start, err)
+	return err
+}
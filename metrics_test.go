@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsTeam(t *testing.T) {
+	previousAccounts := configuredAccounts
+	t.Cleanup(func() { configuredAccounts = previousAccounts })
+	configuredAccounts = &accounts{Teams: []accountTeam{{ID: "team_blah", Slug: "blah"}}}
+
+	tests := []struct {
+		teams  []string
+		teamID string
+		want   string
+	}{
+		{nil, "team_blah", "team_blah"},
+		{nil, "blah", "team_blah"},
+		{nil, "team_random", metricsTeamUnknown},
+		{[]string{"*"}, "team_random", metricsTeamUnknown},
+		{[]string{"team_other"}, "team_other", "team_other"},
+		{[]string{"team_other"}, "team_random", metricsTeamUnknown},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/v8/artifacts/abc?teamId="+test.teamID, nil)
+		req = req.WithContext(withPrincipal(req.Context(), &principal{Name: "test", Teams: test.teams, Read: true}))
+
+		if team := metricsTeam(req, test.teamID); team != test.want {
+			t.Errorf("metricsTeam() with teams %q and team %q returned %q, want %q", test.teams, test.teamID, team, test.want)
+		}
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	previousToken := *metricsToken
+	t.Cleanup(func() { *metricsToken = previousToken })
+
+	handler := MetricsAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		token         string
+		authorization string
+		want          int
+	}{
+		{"", "", http.StatusOK},
+		{"scrape", "", http.StatusUnauthorized},
+		{"scrape", "Bearer wrong", http.StatusUnauthorized},
+		{"scrape", "Bearer scrape", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		*metricsToken = test.token
+
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.want {
+			t.Errorf("GET /metrics with token %q and authorization %q returned %d, want %d", test.token, test.authorization, rec.Code, test.want)
+		}
+	}
+}
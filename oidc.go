@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	oidcIssuer = app.Flag(
+		"oidc.issuer", "The issuer of the accepted JWTs, e.g. https://token.actions.githubusercontent.com, the keys are retrieved via its discovery document unless --oidc.jwks-file is given ($TURBO_OIDC_ISSUER).",
+	).Envar("TURBO_OIDC_ISSUER").String()
+
+	oidcJWKSFile = app.Flag(
+		"oidc.jwks-file", "The path to the JSON Web Key Set to verify the JWTs with, instead of retrieving the keys from the issuer, --oidc.issuer is still required ($TURBO_OIDC_JWKS_FILE).",
+	).Envar("TURBO_OIDC_JWKS_FILE").String()
+
+	oidcAudience = app.Flag(
+		"oidc.audience", "The audience the JWTs must be issued for ($TURBO_OIDC_AUDIENCE).",
+	).Envar("TURBO_OIDC_AUDIENCE").String()
+
+	oidcRulesFile = app.Flag(
+		"oidc.rules-file", "The path to the YAML or JSON file mapping the claims of the JWTs to teams and permissions ($TURBO_OIDC_RULES_FILE).",
+	).Envar("TURBO_OIDC_RULES_FILE").String()
+
+	oidcRefreshInterval = app.Flag(
+		"oidc.refresh-interval", "How often the keys are retrieved from the issuer, or the file passed via --oidc.jwks-file is checked for changes ($TURBO_OIDC_REFRESH_INTERVAL).",
+	).Envar("TURBO_OIDC_REFRESH_INTERVAL").Default("1h").Duration()
+)
+
+// oidcMinRefreshInterval limits how often the keys are retrieved from the
+// issuer when a JWT is signed by an unknown key, e.g. after a key rotation.
+const oidcMinRefreshInterval = time.Minute
+
+// oidcRule grants access to the JWTs that have all the claims of the rule.
+type oidcRule struct {
+	// Name identifies the rule in the logs.
+	Name string `yaml:"name"`
+	// Claims are the values the claims of the JWT must match, the values
+	// can contain wildcards as in shell patterns, e.g. tapico/*.
+	Claims map[string]string `yaml:"claims"`
+	// Teams are the ids or slugs of the teams the JWT has access to, "*"
+	// gives access to all teams.
+	Teams []string `yaml:"teams"`
+	// Permissions are read, write or both.
+	Permissions []string `yaml:"permissions"`
+}
+
+// matches returns whether all the claims of the rule are present in the
+// claims of the JWT, a claim with a list of values matches when one of
+// the values matches.
+func (r *oidcRule) matches(claims map[string]interface{}) bool {
+	for name, pattern := range r.Claims {
+		var values []interface{}
+		switch value := claims[name].(type) {
+		case nil:
+			return false
+		case []interface{}:
+			values = value
+		default:
+			values = []interface{}{value}
+		}
+
+		matched := false
+		for _, value := range values {
+			if ok, _ := path.Match(pattern, fmt.Sprint(value)); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// loadOIDCRules reads the rules, as JSON is valid YAML both formats are read
+// the same way.
+func loadOIDCRules(path string) ([]oidcRule, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Rules []oidcRule `yaml:"rules"`
+	}
+	if err := yaml.UnmarshalStrict(contents, &config); err != nil {
+		return nil, err
+	}
+
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule #%d", i+1)
+		}
+		// A rule without claims would grant access to every JWT of the issuer
+		if len(rule.Claims) == 0 {
+			return nil, fmt.Errorf("%s has no claims", rule.Name)
+		}
+		if len(rule.Teams) == 0 {
+			return nil, fmt.Errorf("%s has no teams, use \"*\" for all teams", rule.Name)
+		}
+		if len(rule.Permissions) == 0 {
+			return nil, fmt.Errorf("%s has no permissions", rule.Name)
+		}
+		for _, permission := range rule.Permissions {
+			if permission != permissionRead && permission != permissionWrite {
+				return nil, fmt.Errorf("%s has the unknown permission '%s'", rule.Name, permission)
+			}
+		}
+	}
+
+	if len(config.Rules) == 0 {
+		return nil, errors.New("no rules are defined")
+	}
+
+	return config.Rules, nil
+}
+
+// oidcVerifier verifies JWTs against the keys of the issuer.
+type oidcVerifier struct {
+	issuer   string
+	audience string
+	rules    []oidcRule
+
+	// jwksURI is where the keys are retrieved from, empty when the keys are
+	// read from --oidc.jwks-file.
+	jwksURI string
+	client  *http.Client
+
+	mu        sync.RWMutex
+	keys      jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+// oidc verifies the JWTs, nil when OpenID Connect isn't configured.
+var oidc *oidcVerifier
+
+func isOIDCEnabled() bool {
+	return *oidcIssuer != "" || *oidcJWKSFile != ""
+}
+
+// discoverJWKSURI retrieves the location of the keys from the discovery
+// document of the issuer.
+func (v *oidcVerifier) discoverJWKSURI() (string, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(strings.TrimSuffix(v.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return "", fmt.Errorf("failed to retrieve the discovery document: %w", err)
+	}
+
+	if discovery.Issuer != v.issuer {
+		return "", fmt.Errorf("the discovery document is of issuer '%s'", discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return "", errors.New("the discovery document has no jwks_uri")
+	}
+	return discovery.JWKSURI, nil
+}
+
+func (v *oidcVerifier) getJSON(url string, value interface{}) error {
+	response, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", response.Status, url)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// refresh reads the keys from the file or retrieves them from the issuer,
+// the current keys are kept when that fails.
+func (v *oidcVerifier) refresh() error {
+	var keys jose.JSONWebKeySet
+	if v.jwksURI == "" {
+		contents, err := os.ReadFile(*oidcJWKSFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(contents, &keys); err != nil {
+			return fmt.Errorf("failed to parse the keys: %w", err)
+		}
+	} else if err := v.getJSON(v.jwksURI, &keys); err != nil {
+		return fmt.Errorf("failed to retrieve the keys: %w", err)
+	}
+
+	if len(keys.Keys) == 0 {
+		return errors.New("no keys are defined")
+	}
+	for _, key := range keys.Keys {
+		// Symmetric keys would allow anyone who can read the keys to sign JWTs
+		if !key.Valid() || !key.IsPublic() {
+			return fmt.Errorf("the key '%s' is not a valid public key", key.KeyID)
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+func (v *oidcVerifier) reload() {
+	if err := v.refresh(); err != nil {
+		logger.Log("message", "failed to refresh the keys of the OIDC issuer, the previous keys are still used", "error", err)
+		return
+	}
+	logger.Log("message", "refreshed the keys of the OIDC issuer")
+}
+
+// key returns the key the JWT was signed with, the keys are retrieved from
+// the issuer again when the key is unknown, as the issuer might have rotated
+// its keys.
+func (v *oidcVerifier) key(header jose.Header) (*jose.JSONWebKey, error) {
+	if key, ok := v.findKey(header); ok {
+		return key, nil
+	}
+
+	v.mu.RLock()
+	recentlyFetched := time.Since(v.fetchedAt) < oidcMinRefreshInterval
+	v.mu.RUnlock()
+
+	if v.jwksURI == "" || recentlyFetched {
+		return nil, fmt.Errorf("unknown key '%s'", header.KeyID)
+	}
+
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+
+	if key, ok := v.findKey(header); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key '%s'", header.KeyID)
+}
+
+func (v *oidcVerifier) findKey(header jose.Header) (*jose.JSONWebKey, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var candidates []jose.JSONWebKey
+	if header.KeyID != "" {
+		candidates = v.keys.Key(header.KeyID)
+	} else if len(v.keys.Keys) == 1 {
+		candidates = v.keys.Keys
+	}
+
+	for _, key := range candidates {
+		if (key.Use == "" || key.Use == "sig") && (key.Algorithm == "" || key.Algorithm == header.Algorithm) {
+			return &key, true
+		}
+	}
+	return nil, false
+}
+
+// verify checks the signature, expiry, audience and issuer of the JWT, and
+// returns the principal of the first rule matching its claims.
+func (v *oidcVerifier) verify(raw string) (*principal, error) {
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(token.Headers) != 1 {
+		return nil, errors.New("the JWT must have a single signature")
+	}
+
+	key, err := v.key(token.Headers[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var standard jwt.Claims
+	var claims map[string]interface{}
+	if err := token.Claims(key, &standard, &claims); err != nil {
+		return nil, err
+	}
+
+	if standard.Expiry == nil {
+		return nil, errors.New("the JWT has no expiry")
+	}
+	err = standard.Validate(jwt.Expected{
+		Issuer:   v.issuer,
+		Audience: jwt.Audience{v.audience},
+		Time:     time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range v.rules {
+		if !rule.matches(claims) {
+			continue
+		}
+
+		who := &principal{Name: fmt.Sprintf("oidc %s (%s)", rule.Name, standard.Subject), Teams: rule.Teams}
+		for _, permission := range rule.Permissions {
+			who.Read = who.Read || permission == permissionRead
+			who.Write = who.Write || permission == permissionWrite
+		}
+		return who, nil
+	}
+
+	return nil, fmt.Errorf("no rule matches the claims of '%s'", standard.Subject)
+}
+
+// initOIDC loads the rules and keys when --oidc.issuer or --oidc.jwks-file is
+// given, the returned function stops refreshing the keys.
+func initOIDC() (func(), error) {
+	if !isOIDCEnabled() {
+		return func() {}, nil
+	}
+
+	// Without an issuer the JWTs of any issuer using the same keys, e.g. of
+	// another environment, would be accepted
+	if *oidcIssuer == "" {
+		return nil, errors.New("--oidc.issuer is required")
+	}
+	if *oidcAudience == "" {
+		return nil, errors.New("--oidc.audience is required")
+	}
+	if *oidcRulesFile == "" {
+		return nil, errors.New("--oidc.rules-file is required")
+	}
+
+	rules, err := loadOIDCRules(*oidcRulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the rules: %w", err)
+	}
+
+	verifier := &oidcVerifier{
+		issuer:   *oidcIssuer,
+		audience: *oidcAudience,
+		rules:    rules,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if *oidcJWKSFile == "" {
+		if verifier.jwksURI, err = verifier.discoverJWKSURI(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := verifier.refresh(); err != nil {
+		return nil, err
+	}
+
+	oidc = verifier
+
+	if *oidcJWKSFile != "" {
+		return watchFiles([]string{*oidcJWKSFile}, *oidcRefreshInterval, verifier.reload), nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(*oidcRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				verifier.reload()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}, nil
+}
+
+// isJWT returns whether the token looks like a JWT, so other tokens are
+// never sent to the verifier.
+func isJWT(token string) bool {
+	return oidc != nil && strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+// verifyJWT returns the principal of a JWT issued by the configured issuer.
+func verifyJWT(token string) (*principal, bool) {
+	who, err := oidc.verify(token)
+	if err != nil {
+		logger.Log("message", "rejected the JWT", "error", err)
+		return nil, false
+	}
+	return who, true
+}
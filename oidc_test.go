@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testOIDCIssuer   = "https://token.actions.githubusercontent.com"
+	testOIDCAudience = "turborepo-remote-cache"
+)
+
+const testOIDCRules = `
+rules:
+  - name: web
+    claims:
+      repository: tapico/web
+      ref: refs/heads/main
+    teams: [team_blah]
+    permissions: [read, write]
+  - name: tapico
+    claims:
+      repository_owner: tapico
+      groups: ci-*
+    teams: ["*"]
+    permissions: [read]
+`
+
+// setupOIDC enables the OIDC authentication for a test with the keys written
+// to a JSON Web Key Set file, the previous values are restored when the test
+// finishes.
+func setupOIDC(t *testing.T, issuer string, keys ...jose.JSONWebKey) {
+	t.Helper()
+
+	previousLogger, previousVerifier := logger, oidc
+	previousIssuer, previousJWKSFile, previousAudience := *oidcIssuer, *oidcJWKSFile, *oidcAudience
+	previousRulesFile, previousInterval := *oidcRulesFile, *oidcRefreshInterval
+	t.Cleanup(func() {
+		logger, oidc = previousLogger, previousVerifier
+		*oidcIssuer, *oidcJWKSFile, *oidcAudience = previousIssuer, previousJWKSFile, previousAudience
+		*oidcRulesFile, *oidcRefreshInterval = previousRulesFile, previousInterval
+	})
+
+	dir := t.TempDir()
+	contents, err := json.Marshal(jose.JSONWebKeySet{Keys: keys})
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "jwks.json"), contents)
+	writeTestFile(t, filepath.Join(dir, "rules.yaml"), []byte(testOIDCRules))
+
+	logger = log.NewNopLogger()
+	oidc = nil
+	*oidcIssuer = issuer
+	*oidcJWKSFile = filepath.Join(dir, "jwks.json")
+	*oidcAudience = testOIDCAudience
+	*oidcRulesFile = filepath.Join(dir, "rules.yaml")
+	*oidcRefreshInterval = time.Hour
+}
+
+func writeTestFile(t *testing.T, path string, contents []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		t.Fatalf("os.WriteFile() failed: %v", err)
+	}
+}
+
+// signJWT returns a JWT with the claims signed by the key.
+func signJWT(t *testing.T, algorithm jose.SignatureAlgorithm, key jose.JSONWebKey, standard jwt.Claims, claims map[string]interface{}) string {
+	t.Helper()
+
+	// The key id isn't added to the header for symmetric keys
+	options := (&jose.SignerOptions{}).WithType("JWT")
+	if key.KeyID != "" {
+		options = options.WithHeader("kid", key.KeyID)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: key}, options)
+	if err != nil {
+		t.Fatalf("jose.NewSigner() failed: %v", err)
+	}
+
+	token, err := jwt.Signed(signer).Claims(standard).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize() failed: %v", err)
+	}
+	return token
+}
+
+func TestInitOIDCRequiresIssuer(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+	setupOIDC(t, "", jose.JSONWebKey{Key: rsaKey.Public(), KeyID: "rsa", Use: "sig"})
+
+	if _, err := initOIDC(); err == nil {
+		t.Fatalf("initOIDC() with only --oidc.jwks-file succeeded, want an error")
+	}
+	if oidc != nil {
+		t.Errorf("initOIDC() enabled the OIDC authentication without an issuer")
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() failed: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+
+	setupOIDC(t, testOIDCIssuer,
+		jose.JSONWebKey{Key: rsaKey.Public(), KeyID: "rsa", Algorithm: string(jose.RS256), Use: "sig"},
+		jose.JSONWebKey{Key: ecKey.Public(), KeyID: "ec", Use: "sig"},
+	)
+	stop, err := initOIDC()
+	if err != nil {
+		t.Fatalf("initOIDC() failed: %v", err)
+	}
+	defer stop()
+
+	rsaSigningKey := jose.JSONWebKey{Key: rsaKey, KeyID: "rsa"}
+	ecSigningKey := jose.JSONWebKey{Key: ecKey, KeyID: "ec"}
+
+	now := time.Now()
+	valid := jwt.Claims{
+		Issuer:   testOIDCIssuer,
+		Subject:  "repo:tapico/web:ref:refs/heads/main",
+		Audience: jwt.Audience{testOIDCAudience},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	}
+	web := map[string]interface{}{"repository": "tapico/web", "ref": "refs/heads/main", "repository_owner": "tapico"}
+	withClaims := func(change func(claims *jwt.Claims)) jwt.Claims {
+		claims := valid
+		change(&claims)
+		return claims
+	}
+
+	tests := []struct {
+		name      string
+		algorithm jose.SignatureAlgorithm
+		key       jose.JSONWebKey
+		standard  jwt.Claims
+		claims    map[string]interface{}
+		want      *principal
+	}{
+		{
+			name: "first matching rule", algorithm: jose.RS256, key: rsaSigningKey, standard: valid, claims: web,
+			want: &principal{Name: "oidc web (repo:tapico/web:ref:refs/heads/main)", Teams: []string{"team_blah"}, Read: true, Write: true},
+		},
+		{
+			name: "wildcard in a list of values", algorithm: jose.ES256, key: ecSigningKey, standard: valid,
+			claims: map[string]interface{}{"repository_owner": "tapico", "groups": []interface{}{"admins", "ci-runners"}},
+			want:   &principal{Name: "oidc tapico (repo:tapico/web:ref:refs/heads/main)", Teams: []string{"*"}, Read: true},
+		},
+		{
+			name: "no matching rule", algorithm: jose.RS256, key: rsaSigningKey, standard: valid,
+			claims: map[string]interface{}{"repository": "tapico/web", "ref": "refs/heads/feature"},
+		},
+		{
+			name: "expired", algorithm: jose.RS256, key: rsaSigningKey, claims: web,
+			standard: withClaims(func(claims *jwt.Claims) { claims.Expiry = jwt.NewNumericDate(now.Add(-5 * time.Minute)) }),
+		},
+		{
+			name: "no expiry", algorithm: jose.RS256, key: rsaSigningKey, claims: web,
+			standard: withClaims(func(claims *jwt.Claims) { claims.Expiry = nil }),
+		},
+		{
+			name: "other audience", algorithm: jose.RS256, key: rsaSigningKey, claims: web,
+			standard: withClaims(func(claims *jwt.Claims) { claims.Audience = jwt.Audience{"sts.amazonaws.com"} }),
+		},
+		{
+			name: "other issuer", algorithm: jose.RS256, key: rsaSigningKey, claims: web,
+			standard: withClaims(func(claims *jwt.Claims) { claims.Issuer = "https://gitlab.com" }),
+		},
+		{
+			name: "algorithm of another key", algorithm: jose.PS256, key: rsaSigningKey, standard: valid, claims: web,
+		},
+		{
+			name: "symmetric algorithm", algorithm: jose.HS256, key: jose.JSONWebKey{Key: []byte("public key as secret"), KeyID: "rsa"}, standard: valid, claims: web,
+		},
+		{
+			name: "unknown key", algorithm: jose.RS256, key: jose.JSONWebKey{Key: otherKey, KeyID: "other"}, standard: valid, claims: web,
+		},
+		{
+			name: "known key id signed by another key", algorithm: jose.RS256, key: jose.JSONWebKey{Key: otherKey, KeyID: "rsa"}, standard: valid, claims: web,
+		},
+		{
+			name: "no key id with several keys", algorithm: jose.RS256, key: jose.JSONWebKey{Key: rsaKey}, standard: valid, claims: web,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := signJWT(t, test.algorithm, test.key, test.standard, test.claims)
+			if !isJWT(token) {
+				t.Fatalf("isJWT() = false, want true")
+			}
+
+			who, ok := verifyJWT(token)
+			if test.want == nil {
+				if ok {
+					t.Errorf("verifyJWT() accepted the JWT as %+v, want it rejected", who)
+				}
+				return
+			}
+
+			if !ok {
+				t.Fatalf("verifyJWT() rejected the JWT")
+			}
+			if who.Name != test.want.Name || who.Read != test.want.Read || who.Write != test.want.Write || !equalStrings(who.Teams, test.want.Teams) {
+				t.Errorf("verifyJWT() = %+v, want %+v", who, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSingleKeyWithoutKeyID(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+	setupOIDC(t, testOIDCIssuer, jose.JSONWebKey{Key: rsaKey.Public(), Use: "sig"})
+
+	stop, err := initOIDC()
+	if err != nil {
+		t.Fatalf("initOIDC() failed: %v", err)
+	}
+	defer stop()
+
+	now := time.Now()
+	token := signJWT(t, jose.RS256, jose.JSONWebKey{Key: rsaKey}, jwt.Claims{
+		Issuer:   testOIDCIssuer,
+		Subject:  "repo:tapico/web:ref:refs/heads/main",
+		Audience: jwt.Audience{testOIDCAudience},
+		Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	}, map[string]interface{}{"repository": "tapico/web", "ref": "refs/heads/main"})
+
+	if _, ok := verifyJWT(token); !ok {
+		t.Errorf("verifyJWT() rejected the JWT signed by the only key")
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
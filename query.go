@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/graymeta/stow"
+)
+
+// maxConcurrentArtifactQueries limits the number of simultaneous requests made
+// to the storage provider while answering a single batch query.
+const maxConcurrentArtifactQueries = 16
+
+// artifactQueryRequest is the body turbo sends to `POST /v8/artifacts`.
+type artifactQueryRequest struct {
+	Hashes []string `json:"hashes"`
+}
+
+// artifactInfo describes a cache artefact that exists in the remote cache.
+type artifactInfo struct {
+	Size           int64  `json:"size"`
+	TaskDurationMs int64  `json:"taskDurationMs"`
+	Tag            string `json:"tag,omitempty"`
+}
+
+// artifactQueryError is returned for hashes that could not be resolved.
+type artifactQueryError struct {
+	Error artifactQueryErrorDetail `json:"error"`
+}
+
+type artifactQueryErrorDetail struct {
+	Message string `json:"message"`
+}
+
+// queryCacheItems answers a batch query for multiple cache artefacts at once,
+// each hash is looked up concurrently in the storage provider.
+func queryCacheItems(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "queryCacheItems()")
+
+	query := r.URL.Query()
+	if !query.Has("teamId") && !query.Has("slug") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(`{"error":{"message":"teamID or slug is missing","code":"required"}}`))
+		return
+	}
+
+	var body artifactQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		logger.Log("message", "failed to decode the artifact query", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"invalid request body","code":"bad_request"}}`))
+		return
+	}
+
+	// The hashes are used as path of the cache artefacts, so a hash must not
+	// refer to the cache artefacts of another team
+	for _, hash := range body.Hashes {
+		if !isSafePathSegment(hash) {
+			logger.Log("message", "rejecting the artifact query with an invalid hash", "hash", hash)
+			writeInvalidHashError(w, hash)
+			return
+		}
+	}
+
+	teamID := getTeamID(query)
+	team, err := resolveTenant(teamID)
+	if err != nil {
+		logger.Log("message", "failed to resolve the bucket of the team", "teamID", teamID, "error", err)
+		writeInvalidTeamError(w, err)
+		return
+	}
+	logger.Log("message", "received the following", "teamID", teamID, "bucket", team.Bucket, "hashes", len(body.Hashes))
+
+	if !isCacheReadable(teamID) {
+		logger.Log("message", "reporting all artifacts as missing as remote caching is not enabled for the team", "teamID", teamID)
+		results := make(map[string]interface{}, len(body.Hashes))
+		for _, hash := range body.Hashes {
+			results[hash] = artifactQueryError{Error: artifactQueryErrorDetail{Message: "Artifact not found"}}
+		}
+		writeArtifactQueryResponse(w, results)
+		return
+	}
+
+	container, err := GetContainerByName(team.Bucket)
+	if err != nil || container == nil {
+		logger.Log("message", "failed to get container for artifact query", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"message":"failed to query cache items","code":"internal_error"}}`))
+		return
+	}
+
+	// turbo can send the same hash more than once, each hash is only looked
+	// up once and every lookup writes to its own slot
+	seen := make(map[string]bool, len(body.Hashes))
+	var hashes []string
+	for _, hash := range body.Hashes {
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+
+	lookups := make([]interface{}, len(hashes))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentArtifactQueries)
+
+	for i, hash := range hashes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, hash string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			lookups[i] = queryCacheItem(container, team.ArtefactPath(hash))
+		}(i, hash)
+	}
+
+	wg.Wait()
+
+	results := make(map[string]interface{}, len(hashes))
+	for i, hash := range hashes {
+		results[hash] = lookups[i]
+	}
+
+	writeArtifactQueryResponse(w, results)
+}
+
+func writeArtifactQueryResponse(w http.ResponseWriter, results map[string]interface{}) {
+	response, err := json.Marshal(results)
+	if err != nil {
+		logger.Log("message", "failed to encode the artifact query response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// queryCacheItem returns the information turbo needs about a single artefact,
+// or an error object when the artefact can't be found.
+func queryCacheItem(container stow.Container, path string) interface{} {
+	item, err := container.Item(path)
+	if err != nil {
+		if err != stow.ErrNotFound {
+			logger.Log("message", "failed to query item from cloud storage", "path", path, "error", err)
+		}
+		return artifactQueryError{Error: artifactQueryErrorDetail{Message: "Artifact not found"}}
+	}
+
+	size, err := item.Size()
+	if err != nil {
+		logger.Log("message", "failed to determine size of cache item", "path", path, "error", err)
+		return artifactQueryError{Error: artifactQueryErrorDetail{Message: "Failed to read artifact"}}
+	}
+
+	duration, _ := strconv.ParseInt(getItemMetadata(item, metadataArtifactDuration), 10, 64)
+
+	return artifactInfo{
+		Size:           size,
+		TaskDurationMs: duration,
+		Tag:            getItemMetadata(item, metadataArtifactTag),
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/graymeta/stow"
+)
+
+const (
+	quotaPolicyEvict  = "evict"
+	quotaPolicyReject = "reject"
+)
+
+var (
+	quotaMaxSize = app.Flag(
+		"quota.max-size", "The maximum size of all the cache artefacts of a team, 0 disables the quota ($TURBO_QUOTA_MAX_SIZE).",
+	).Envar("TURBO_QUOTA_MAX_SIZE").Default("0").Bytes()
+
+	quotaTeamMaxSize = app.Flag(
+		"quota.team-max-size", "The maximum size of all the cache artefacts of a team, in the form of team=size (repeatable).",
+	).StringMap()
+
+	quotaPolicy = app.Flag(
+		"quota.policy", "What to do when an upload exceeds the quota of a team: evict the least recently read cache artefacts, or reject the upload ($TURBO_QUOTA_POLICY).",
+	).Envar("TURBO_QUOTA_POLICY").Default(quotaPolicyEvict).Enum(quotaPolicyEvict, quotaPolicyReject)
+)
+
+// teamQuota holds the sizes passed via --quota.team-max-size.
+var teamQuota = make(map[string]int64)
+
+// initQuotas parses the quotas of the teams.
+func initQuotas() error {
+	for teamID, value := range *quotaTeamMaxSize {
+		size, err := units.ParseBase2Bytes(value)
+		if err != nil || size < 0 {
+			return fmt.Errorf("invalid quota '%s' for team '%s'", value, teamID)
+		}
+		teamQuota[teamID] = int64(size)
+	}
+	return nil
+}
+
+// getQuota returns the maximum size of all the cache artefacts of the team, a
+// quota of 0 means the team has no quota.
+func getQuota(teamID string) int64 {
+	if size, ok := teamQuota[teamID]; ok {
+		return size
+	}
+
+	// The quota of a team defined via --accounts.config can be set by slug
+	if configuredAccounts != nil {
+		if team, ok := configuredAccounts.findTeam(teamID); ok {
+			if size, ok := teamQuota[team.Slug]; ok {
+				return size
+			}
+		}
+	}
+	return int64(*quotaMaxSize)
+}
+
+// errQuotaExceeded is returned when an upload doesn't fit in the quota of
+// the team.
+var errQuotaExceeded = errors.New("the storage quota of the team is exceeded")
+
+// writeQuotaExceeded rejects an upload that doesn't fit in the quota of a
+// team that isn't over its quota yet, so turbo keeps using the remote cache.
+func writeQuotaExceeded(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write([]byte(`{"error":{"message":"the artifact doesn't fit in the storage quota of the team","code":"quota_exceeded"}}`))
+}
+
+// usageEntry is a cache artefact that counts towards the quota of a team.
+type usageEntry struct {
+	team       *teamUsage
+	path       string
+	size       int64
+	lastAccess time.Time
+	// pending is true while the cache artefact is being uploaded, it's
+	// counted towards the quota but is never evicted.
+	pending bool
+}
+
+// teamUsage tracks the size of all the cache artefacts of a team, it's kept
+// per location of the cache artefacts of the team.
+type teamUsage struct {
+	// mu serialises reserving room for the uploads of the team, so
+	// concurrent uploads can't exceed the quota together. It's released
+	// before the upload starts, the reserved size counts towards the quota.
+	mu sync.Mutex
+
+	teamID string
+	bucket string
+	loaded bool
+	size   int64
+}
+
+// quotaIndex keeps the size and the last time a cache artefact was read, for
+// all the teams that have a quota.
+type quotaIndex struct {
+	mu      sync.Mutex
+	teams   map[string]*teamUsage
+	entries map[string]*usageEntry
+}
+
+var quotas = &quotaIndex{
+	teams:   make(map[string]*teamUsage),
+	entries: make(map[string]*usageEntry),
+}
+
+func (q *quotaIndex) getTeam(team tenant) *teamUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := tierKey(team.Bucket, team.Prefix)
+	usage, ok := q.teams[key]
+	if !ok {
+		usage = &teamUsage{teamID: team.TeamID, bucket: team.Bucket}
+		q.teams[key] = usage
+	}
+	return usage
+}
+
+// IsOverLimit returns whether the stored cache artefacts of the team fill its
+// quota while --quota.policy=reject is used, only uploads replacing a cache
+// artefact with one that isn't larger are accepted until cache artefacts of
+// the team are removed.
+func (q *quotaIndex) IsOverLimit(team tenant) bool {
+	quota := getQuota(team.TeamID)
+	if quota <= 0 || *quotaPolicy != quotaPolicyReject {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, ok := q.teams[tierKey(team.Bucket, team.Prefix)]
+	return ok && usage.size >= quota
+}
+
+// load walks the cache artefacts of the team that are already stored, the
+// last access time is taken from the index of access times, or the
+// modification time when the cache artefact is newer or hasn't been read. The
+// caller must hold the lock of the team.
+func (q *quotaIndex) load(usage *teamUsage, team tenant) error {
+	if usage.loaded {
+		return nil
+	}
+
+	container, err := GetContainerByName(team.Bucket)
+	if err != nil {
+		return err
+	}
+
+	cursor := stow.CursorStart
+	for {
+		items, next, err := container.Items(team.Prefix, cursor, gcPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			size, err := item.Size()
+			if err != nil {
+				continue
+			}
+			lastAccess, err := item.LastMod()
+			if err != nil {
+				lastAccess = time.Now()
+			}
+			if at, ok := getLastAccess(team.Bucket, item.Name()); ok && at.After(lastAccess) {
+				lastAccess = at
+			}
+			q.set(usage, item.Name(), size, lastAccess, false)
+		}
+
+		if stow.IsCursorEnd(next) {
+			break
+		}
+		cursor = next
+	}
+
+	usage.loaded = true
+	logger.Log("message", "loaded the storage usage of team", "teamID", team.TeamID, "size", usage.size)
+	return nil
+}
+
+// set records the size of the cache artefact stored at the path.
+func (q *quotaIndex) set(usage *teamUsage, path string, size int64, lastAccess time.Time, pending bool) *usageEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := tierKey(usage.bucket, path)
+	if entry, ok := q.entries[key]; ok {
+		entry.team.size -= entry.size
+	}
+
+	entry := &usageEntry{team: usage, path: path, size: size, lastAccess: lastAccess, pending: pending}
+	q.entries[key] = entry
+	usage.size += size
+	return entry
+}
+
+// settle completes the reservation of an upload. When the upload failed, the
+// cache artefact it replaced is restored, unless another upload of the same
+// cache artefact reserved room in the meantime.
+func (q *quotaIndex) settle(entry *usageEntry, previous *usageEntry, stored bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := tierKey(entry.team.bucket, entry.path)
+	if q.entries[key] != entry {
+		return
+	}
+
+	if stored {
+		entry.pending = false
+		return
+	}
+
+	entry.team.size -= entry.size
+	if previous != nil {
+		// The restored entry is no longer settled by the upload that
+		// reserved it, so it must remain evictable
+		previous.pending = false
+		q.entries[key] = previous
+		entry.team.size += previous.size
+	} else {
+		delete(q.entries, key)
+	}
+}
+
+// Touch records that the cache artefact was read.
+func (q *quotaIndex) Touch(bucket string, path string, lastAccess time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if entry, ok := q.entries[tierKey(bucket, path)]; ok && lastAccess.After(entry.lastAccess) {
+		entry.lastAccess = lastAccess
+	}
+}
+
+// Forget removes a cache artefact that was removed from the storage provider.
+func (q *quotaIndex) Forget(bucket string, path string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := tierKey(bucket, path)
+	entry, ok := q.entries[key]
+	if !ok {
+		return
+	}
+
+	delete(q.entries, key)
+	entry.team.size -= entry.size
+}
+
+// leastRecentlyRead returns the stored cache artefacts of the team, the least
+// recently read first. Cache artefacts that are being uploaded are skipped.
+func (q *quotaIndex) leastRecentlyRead(usage *teamUsage) []*usageEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var entries []*usageEntry
+	for _, entry := range q.entries {
+		if entry.team == usage && !entry.pending {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastAccess.Before(entries[j].lastAccess)
+	})
+	return entries
+}
+
+// Reserve makes room for a cache artefact of the given size in the quota of
+// the team, depending on --quota.policy the least recently read cache
+// artefacts are removed or errQuotaExceeded is returned. The size is reserved
+// until the returned function is called with the outcome of the upload, other
+// uploads of the team don't wait for the upload to finish.
+func (q *quotaIndex) Reserve(team tenant, path string, size int64) (func(stored bool), error) {
+	quota := getQuota(team.TeamID)
+	if quota <= 0 {
+		return func(bool) {}, nil
+	}
+
+	usage := q.getTeam(team)
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+
+	if err := q.load(usage, team); err != nil {
+		return nil, err
+	}
+
+	// The size of the cache artefact that is replaced is freed
+	q.mu.Lock()
+	var previous *usageEntry
+	if entry, ok := q.entries[tierKey(team.Bucket, path)]; ok {
+		copied := *entry
+		previous = &copied
+	}
+	required := usage.size + size - quota
+	if previous != nil {
+		required -= previous.size
+	}
+	q.mu.Unlock()
+
+	if size > quota || (required > 0 && *quotaPolicy == quotaPolicyReject) {
+		return nil, errQuotaExceeded
+	}
+
+	if required > 0 {
+		if err := q.evict(usage, team, path, required); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := q.set(usage, path, size, time.Now(), true)
+	return func(stored bool) {
+		q.settle(entry, previous, stored)
+	}, nil
+}
+
+// evict removes the least recently read cache artefacts of the team until at
+// least the required number of bytes is freed. The caller must hold the lock
+// of the team.
+func (q *quotaIndex) evict(usage *teamUsage, team tenant, path string, required int64) error {
+	container, err := GetContainerByName(team.Bucket)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range q.leastRecentlyRead(usage) {
+		if required <= 0 {
+			break
+		}
+		if entry.path == path {
+			continue
+		}
+
+		if err := container.RemoveItem(entry.path); err != nil && err != stow.ErrNotFound {
+			logger.Log("message", "failed to evict cache item", "teamID", team.TeamID, "path", entry.path, "error", err)
+			continue
+		}
+
+		logger.Log("message", "evicted cache item to stay within the quota", "teamID", team.TeamID, "path", entry.path, "size", entry.size, "lastAccess", entry.lastAccess)
+		forgetArtifact(team.Bucket, entry.path)
+		required -= entry.size
+	}
+
+	if required > 0 {
+		return errQuotaExceeded
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alecthomas/units"
+)
+
+// setupQuota configures the quota of all teams for a test, the previous values
+// are restored when the test finishes.
+func setupQuota(t *testing.T, maxSize int64, policy string) {
+	t.Helper()
+
+	previousMaxSize, previousPolicy, previousQuotas := *quotaMaxSize, *quotaPolicy, quotas
+	previousStatus := *cacheStatus
+	t.Cleanup(func() {
+		*quotaMaxSize, *quotaPolicy, quotas = previousMaxSize, previousPolicy, previousQuotas
+		*cacheStatus = previousStatus
+	})
+
+	*cacheStatus = cacheStatusEnabled
+	*quotaMaxSize = units.Base2Bytes(maxSize)
+	*quotaPolicy = policy
+	quotas = &quotaIndex{
+		teams:   make(map[string]*teamUsage),
+		entries: make(map[string]*usageEntry),
+	}
+}
+
+func TestReserveRejectPolicy(t *testing.T) {
+	setupTenancy(t, "memory", false, "{{.Hash}}")
+	setupQuota(t, 10, quotaPolicyReject)
+
+	team, err := resolveTenant("team_blah")
+	if err != nil {
+		t.Fatalf("resolveTenant() failed: %v", err)
+	}
+
+	reserve := func(name string, size int64) error {
+		release, err := quotas.Reserve(team, team.ArtefactPath(name), size)
+		if err == nil {
+			release(true)
+		}
+		return err
+	}
+
+	if err := reserve("a", 9); err != nil {
+		t.Fatalf("Reserve() of 9 bytes failed: %v", err)
+	}
+
+	// Only the upload that doesn't fit is rejected
+	if err := reserve("b", 2); err != errQuotaExceeded {
+		t.Errorf("Reserve() of 2 bytes returned %v, want errQuotaExceeded", err)
+	}
+	if quotas.IsOverLimit(team) || getCacheStatus(team.TeamID) != cacheStatusEnabled {
+		t.Errorf("the team is over its limit after a rejected upload, want enabled")
+	}
+
+	if err := reserve("c", 1); err != nil {
+		t.Fatalf("Reserve() of 1 byte failed: %v", err)
+	}
+	if status := getCacheStatus(team.TeamID); status != cacheStatusOverLimit {
+		t.Errorf("got status %q for a full quota, want %q", status, cacheStatusOverLimit)
+	}
+
+	// Replacing a cache artefact with one that isn't larger still fits
+	if err := reserve("a", 9); err != nil {
+		t.Errorf("Reserve() replacing a cache artefact failed: %v", err)
+	}
+
+	quotas.Forget(team.Bucket, team.ArtefactPath("c"))
+	if quotas.IsOverLimit(team) {
+		t.Errorf("the team is over its limit after removing a cache artefact")
+	}
+}
+
+func TestQuotaSharedByIDAndSlug(t *testing.T) {
+	setupTenancy(t, "memory", false, "{{.Hash}}")
+	setupQuota(t, 10, quotaPolicyReject)
+
+	previousAccounts := configuredAccounts
+	t.Cleanup(func() {
+		configuredAccounts = previousAccounts
+	})
+	configuredAccounts = &accounts{Teams: []accountTeam{{ID: "team_blah", Slug: "blah"}}}
+
+	byID, err := resolveTenant("team_blah")
+	if err != nil {
+		t.Fatalf("resolveTenant() failed: %v", err)
+	}
+	bySlug, err := resolveTenant("blah")
+	if err != nil {
+		t.Fatalf("resolveTenant() failed: %v", err)
+	}
+	if byID != bySlug {
+		t.Fatalf("the id resolves to %+v and the slug to %+v, want the same tenant", byID, bySlug)
+	}
+
+	release, err := quotas.Reserve(byID, byID.ArtefactPath("a"), 8)
+	if err != nil {
+		t.Fatalf("Reserve() failed: %v", err)
+	}
+	release(true)
+
+	if _, err := quotas.Reserve(bySlug, bySlug.ArtefactPath("b"), 8); err != errQuotaExceeded {
+		t.Errorf("Reserve() by slug returned %v, want errQuotaExceeded", err)
+	}
+}
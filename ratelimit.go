@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	rateLimitReads  = "reads"
+	rateLimitWrites = "writes"
+)
+
+var (
+	rateLimitReadsPerSecond = app.Flag(
+		"rate-limit.reads", "The number of requests per second reading from the cache a token can make for a team, 0 disables the limit ($TURBO_RATE_LIMIT_READS).",
+	).Envar("TURBO_RATE_LIMIT_READS").Default("0").Float64()
+
+	rateLimitReadBurst = app.Flag(
+		"rate-limit.read-burst", "The number of requests reading from the cache a token can make for a team at once ($TURBO_RATE_LIMIT_READ_BURST).",
+	).Envar("TURBO_RATE_LIMIT_READ_BURST").Default("100").Int()
+
+	rateLimitWritesPerSecond = app.Flag(
+		"rate-limit.writes", "The number of uploads per second a token can make for a team, 0 disables the limit ($TURBO_RATE_LIMIT_WRITES).",
+	).Envar("TURBO_RATE_LIMIT_WRITES").Default("0").Float64()
+
+	rateLimitWriteBurst = app.Flag(
+		"rate-limit.write-burst", "The number of uploads a token can make for a team at once ($TURBO_RATE_LIMIT_WRITE_BURST).",
+	).Envar("TURBO_RATE_LIMIT_WRITE_BURST").Default("20").Int()
+
+	rateLimitBytesPerSecond = app.Flag(
+		"rate-limit.bytes", "The number of bytes per second a token can upload and download for a team, 0 disables the limit ($TURBO_RATE_LIMIT_BYTES).",
+	).Envar("TURBO_RATE_LIMIT_BYTES").Default("0").Bytes()
+
+	rateLimitBytesBurst = app.Flag(
+		"rate-limit.bytes-burst", "The number of bytes a token can upload and download for a team at once ($TURBO_RATE_LIMIT_BYTES_BURST).",
+	).Envar("TURBO_RATE_LIMIT_BYTES_BURST").Default("256MB").Bytes()
+)
+
+// rateLimitIdleTimeout is how long the limiters of a token and team are kept
+// after its last request, a new limiter starts with a full bucket.
+const rateLimitIdleTimeout = 10 * time.Minute
+
+// rateLimitMaxLimiters is the number of limiters that are kept at most, the
+// least recently used limiters are removed first.
+const rateLimitMaxLimiters = 10000
+
+// rateLimitKey identifies the limiters, the id of the token is used so every
+// token has its own limits, or the name of the principal when it
+// authenticated with a client certificate. The team is empty for principals
+// with access to all teams, which share their limits between the teams.
+type rateLimitKey struct {
+	credential string
+	team       string
+}
+
+// rateLimiters are the token buckets of a token and team.
+type rateLimiters struct {
+	reads    *rate.Limiter
+	writes   *rate.Limiter
+	bytes    *rate.Limiter
+	lastUsed time.Time
+}
+
+// rateLimits holds the limiters of the tokens and teams that made a request
+// recently.
+var rateLimits = struct {
+	sync.Mutex
+	limiters map[rateLimitKey]*rateLimiters
+}{limiters: make(map[rateLimitKey]*rateLimiters)}
+
+func isRateLimitEnabled() bool {
+	return *rateLimitReadsPerSecond > 0 || *rateLimitWritesPerSecond > 0 || *rateLimitBytesPerSecond > 0
+}
+
+// newLimiter returns a token bucket, or nil when the limit is disabled.
+func newLimiter(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// getRateLimiters returns the limiters of the token and team, creating them
+// on the first request.
+func getRateLimiters(key rateLimitKey, now time.Time) *rateLimiters {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+
+	limiters, ok := rateLimits.limiters[key]
+	if !ok {
+		if len(rateLimits.limiters) >= rateLimitMaxLimiters {
+			removeLeastRecentlyUsedRateLimiters()
+		}
+
+		limiters = &rateLimiters{
+			reads:  newLimiter(*rateLimitReadsPerSecond, *rateLimitReadBurst),
+			writes: newLimiter(*rateLimitWritesPerSecond, *rateLimitWriteBurst),
+			bytes:  newLimiter(float64(*rateLimitBytesPerSecond), int(*rateLimitBytesBurst)),
+		}
+		rateLimits.limiters[key] = limiters
+	}
+	limiters.lastUsed = now
+	return limiters
+}
+
+// removeLeastRecentlyUsedRateLimiters forgets the least recently used
+// limiters to make room for a new limiter, the caller must hold the lock.
+func removeLeastRecentlyUsedRateLimiters() {
+	var oldestKey rateLimitKey
+	var oldest time.Time
+	for key, limiters := range rateLimits.limiters {
+		if oldest.IsZero() || limiters.lastUsed.Before(oldest) {
+			oldestKey, oldest = key, limiters.lastUsed
+		}
+	}
+	delete(rateLimits.limiters, oldestKey)
+}
+
+// removeIdleRateLimiters forgets the limiters that weren't used recently, so
+// the number of limiters doesn't grow with every token and team ever seen.
+func removeIdleRateLimiters(now time.Time) {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+
+	for key, limiters := range rateLimits.limiters {
+		if now.Sub(limiters.lastUsed) > rateLimitIdleTimeout {
+			delete(rateLimits.limiters, key)
+		}
+	}
+}
+
+// initRateLimits validates the limits, the returned function stops removing
+// the idle limiters.
+func initRateLimits() (func(), error) {
+	if !isRateLimitEnabled() {
+		return func() {}, nil
+	}
+
+	if *rateLimitReadsPerSecond > 0 && *rateLimitReadBurst < 1 {
+		return nil, errors.New("--rate-limit.read-burst must be at least 1")
+	}
+	if *rateLimitWritesPerSecond > 0 && *rateLimitWriteBurst < 1 {
+		return nil, errors.New("--rate-limit.write-burst must be at least 1")
+	}
+	if *rateLimitBytesPerSecond > 0 && (*rateLimitBytesBurst < 1 || *rateLimitBytesBurst > math.MaxInt32) {
+		return nil, errors.New("--rate-limit.bytes-burst must be between 1 byte and 2GB")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				removeIdleRateLimiters(now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}, nil
+}
+
+// rateLimitTeam returns the team the limits of the request are kept for. The
+// principals that have access to all teams share their limits between the
+// teams, as they could otherwise get new limits by using another team. The
+// other principals were authorized for the team, so the number of teams
+// they use is limited.
+func rateLimitTeam(req *http.Request, who *principal) string {
+	if who.Teams == nil {
+		return ""
+	}
+	for _, allowed := range who.Teams {
+		if allowed == "*" {
+			return ""
+		}
+	}
+
+	// By id when the slug of a team defined via --accounts.config is used, so
+	// both share the limits
+	return canonicalTeamID(getTeamID(req.URL.Query()))
+}
+
+// throttledReader waits for the bytes bucket before the bytes of an upload are
+// read, so the upload is slowed down to the limit.
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (r *throttledReader) Read(b []byte) (int, error) {
+	// A chunk can't be larger than the burst
+	if len(b) > r.limiter.Burst() {
+		b = b[:r.limiter.Burst()]
+	}
+
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// throttledResponseWriter waits for the bytes bucket before the bytes of a
+// download are written, so the download is slowed down to the limit.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (w *throttledResponseWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > w.limiter.Burst() {
+			chunk = chunk[:w.limiter.Burst()]
+		}
+
+		if err := w.limiter.WaitN(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Flush sends the buffered bytes to the client, when the underlying writer
+// supports it.
+func (w *throttledResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// writeRateLimited rejects the request, turbo retries the request after the
+// duration passed via Retry-After.
+func writeRateLimited(res http.ResponseWriter, delay time.Duration) {
+	seconds := strconv.Itoa(int(math.Ceil(delay.Seconds())))
+	res.Header().Set("Retry-After", seconds)
+	writeJSON(res, http.StatusTooManyRequests, map[string]interface{}{
+		"error": map[string]string{
+			"message": "too many requests for the given TURBO_TOKEN and team, retry after " + seconds + " seconds",
+			"code":    "rate_limited",
+		},
+	})
+}
+
+// RateLimitMiddleware limits the requests per token and team with token
+// buckets, reads and writes have their own bucket. Requests exceeding these
+// limits are rejected with 429 Too Many Requests. The uploaded and downloaded
+// bytes share a bucket, the uploads and downloads are slowed down to the limit
+// instead of being rejected.
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	if !isRateLimitEnabled() {
+		return next
+	}
+
+	fn := func(res http.ResponseWriter, req *http.Request) {
+		who := getPrincipal(req.Context())
+		if who == nil {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		now := time.Now()
+		team := rateLimitTeam(req, who)
+		credential := who.ID
+		if credential == "" {
+			credential = who.Name
+		}
+		limiters := getRateLimiters(rateLimitKey{credential: credential, team: team}, now)
+
+		limit, requests := rateLimitReads, limiters.reads
+		if requiredPermission(req) == permissionWrite {
+			limit, requests = rateLimitWrites, limiters.writes
+		}
+
+		if requests != nil {
+			reservation := requests.ReserveN(now, 1)
+			if delay := reservation.DelayFrom(now); delay > 0 {
+				reservation.CancelAt(now)
+				rateLimitedRequests.WithLabelValues(limit).Inc()
+				logger.Log("message", "rate limited the request", "principal", who.Name, "teamID", team, "limit", limit, "retryAfter", delay)
+				writeRateLimited(res, delay)
+				return
+			}
+		}
+
+		// The size of an upload isn't known upfront when it's sent in chunks,
+		// so every chunk waits for the bucket while it's read or written
+		if limiters.bytes != nil {
+			if limit == rateLimitWrites {
+				req.Body = &throttledReader{ReadCloser: req.Body, ctx: req.Context(), limiter: limiters.bytes}
+			} else {
+				res = &throttledResponseWriter{ResponseWriter: res, ctx: req.Context(), limiter: limiters.bytes}
+			}
+		}
+
+		next.ServeHTTP(res, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/go-kit/log"
+	"golang.org/x/time/rate"
+)
+
+// setupRateLimits configures the rate limits for a test, the previous values
+// are restored when the test finishes.
+func setupRateLimits(t *testing.T, reads float64, readBurst int, bytesPerSecond int64, bytesBurst int64) {
+	t.Helper()
+
+	previousLogger, previousAccounts := logger, configuredAccounts
+	previousReads, previousReadBurst := *rateLimitReadsPerSecond, *rateLimitReadBurst
+	previousWrites := *rateLimitWritesPerSecond
+	previousBytes, previousBytesBurst := *rateLimitBytesPerSecond, *rateLimitBytesBurst
+	t.Cleanup(func() {
+		logger, configuredAccounts = previousLogger, previousAccounts
+		*rateLimitReadsPerSecond, *rateLimitReadBurst = previousReads, previousReadBurst
+		*rateLimitWritesPerSecond = previousWrites
+		*rateLimitBytesPerSecond, *rateLimitBytesBurst = previousBytes, previousBytesBurst
+
+		rateLimits.Lock()
+		rateLimits.limiters = make(map[rateLimitKey]*rateLimiters)
+		rateLimits.Unlock()
+	})
+
+	logger = log.NewNopLogger()
+	*rateLimitReadsPerSecond, *rateLimitReadBurst = reads, readBurst
+	*rateLimitWritesPerSecond = 0
+	*rateLimitBytesPerSecond, *rateLimitBytesBurst = units.Base2Bytes(bytesPerSecond), units.Base2Bytes(bytesBurst)
+}
+
+func TestRateLimitTeam(t *testing.T) {
+	setupRateLimits(t, 1, 1, 0, 0)
+	configuredAccounts = &accounts{Teams: []accountTeam{{ID: "team_blah", Slug: "blah"}}}
+
+	tests := []struct {
+		teams []string
+		query string
+		want  string
+	}{
+		{nil, "teamId=team_blah", ""},
+		{nil, "teamId=team_other", ""},
+		{[]string{"*"}, "teamId=team_other", ""},
+		{[]string{"team_blah"}, "teamId=team_blah", "team_blah"},
+		{[]string{"team_blah"}, "slug=blah", "team_blah"},
+		{[]string{"team_other"}, "teamId=team_other", "team_other"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v8/artifacts/abc?"+test.query, nil)
+		who := &principal{Name: "test", Teams: test.teams, Read: true}
+
+		if team := rateLimitTeam(req, who); team != test.want {
+			t.Errorf("rateLimitTeam() for teams %q and query %q returned %q, want %q", test.teams, test.query, team, test.want)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	setupRateLimits(t, 0.001, 2, 0, 0)
+
+	handler := RateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(who *principal, teamID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v8/artifacts/abc?teamId="+teamID, nil)
+		req = req.WithContext(withPrincipal(req.Context(), who))
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	// A token with access to all teams can't get new limits by using
+	// another team
+	all := fullAccess("turbo-token").withID("a")
+	for _, teamID := range []string{"team_a", "team_b"} {
+		if res := request(all, teamID); res.Code != http.StatusOK {
+			t.Errorf("request for %s returned %d, want 200", teamID, res.Code)
+		}
+	}
+	if res := request(all, "team_c"); res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") == "" {
+		t.Errorf("third request returned %d, want 429 with Retry-After", res.Code)
+	}
+
+	// Another token has its own limits, a token limited to teams has limits
+	// per team
+	scoped := (&principal{Name: "scoped", Teams: []string{"team_a", "team_b"}, Read: true}).withID("b")
+	for _, teamID := range []string{"team_a", "team_a", "team_b", "team_b"} {
+		if res := request(scoped, teamID); res.Code != http.StatusOK {
+			t.Errorf("request of a scoped token for %s returned %d, want 200", teamID, res.Code)
+		}
+	}
+	if res := request(scoped, "team_a"); res.Code != http.StatusTooManyRequests {
+		t.Errorf("third request of a scoped token for team_a returned %d, want 429", res.Code)
+	}
+}
+
+func TestRateLimitersAreCapped(t *testing.T) {
+	setupRateLimits(t, 1, 1, 0, 0)
+
+	now := time.Now()
+	for i := 0; i < rateLimitMaxLimiters+10; i++ {
+		getRateLimiters(rateLimitKey{credential: fmt.Sprint(i)}, now.Add(time.Duration(i)))
+	}
+
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+
+	if len(rateLimits.limiters) != rateLimitMaxLimiters {
+		t.Errorf("got %d limiters, want %d", len(rateLimits.limiters), rateLimitMaxLimiters)
+	}
+	if _, ok := rateLimits.limiters[rateLimitKey{credential: "0"}]; ok {
+		t.Errorf("the least recently used limiter was kept")
+	}
+	if _, ok := rateLimits.limiters[rateLimitKey{credential: fmt.Sprint(rateLimitMaxLimiters + 9)}]; !ok {
+		t.Errorf("the most recently used limiter was removed")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	limiter := rate.NewLimiter(1000, 100)
+	body := bytes.Repeat([]byte("a"), 300)
+	req := httptest.NewRequest(http.MethodPut, "/v8/artifacts/abc", bytes.NewReader(body))
+
+	reader := &throttledReader{ReadCloser: req.Body, ctx: req.Context(), limiter: limiter}
+
+	started := time.Now()
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading failed: %v", err)
+	}
+	if !bytes.Equal(read, body) {
+		t.Errorf("read %d bytes, want the %d bytes of the body", len(read), len(body))
+	}
+
+	// The burst is available at once, the other 200 bytes take 200ms
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond {
+		t.Errorf("reading took %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestThrottledResponseWriter(t *testing.T) {
+	limiter := rate.NewLimiter(1000, 100)
+	req := httptest.NewRequest(http.MethodGet, "/v8/artifacts/abc", nil)
+	res := httptest.NewRecorder()
+
+	var writer http.ResponseWriter = &throttledResponseWriter{ResponseWriter: res, ctx: req.Context(), limiter: limiter}
+
+	started := time.Now()
+	if _, err := io.Copy(writer, bytes.NewReader(bytes.Repeat([]byte("a"), 300))); err != nil {
+		t.Fatalf("writing failed: %v", err)
+	}
+	if res.Body.Len() != 300 {
+		t.Errorf("wrote %d bytes, want 300", res.Body.Len())
+	}
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond {
+		t.Errorf("writing took %v, want at least 200ms", elapsed)
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		t.Fatalf("the writer doesn't implement http.Flusher")
+	}
+	flusher.Flush()
+	if !res.Flushed {
+		t.Errorf("Flush() wasn't passed to the underlying writer")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileState is what is compared to notice that a file changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths []string) []fileState {
+	states := make([]fileState, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			states[i] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return states
+}
+
+// watchFiles calls onChange when one of the files changed, the files are
+// polled every interval instead of relying on file system events, as those
+// are easily missed when a Kubernetes secret or config map is updated by
+// replacing a symlink. The returned function stops watching.
+func watchFiles(paths []string, interval time.Duration, onChange func()) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		previous := statFiles(paths)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := statFiles(paths)
+				for i := range current {
+					if current[i] != previous[i] {
+						onChange()
+						break
+					}
+				}
+				previous = current
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
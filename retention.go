@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graymeta/stow"
+)
+
+// gcPageSize is the number of cache artefacts requested per page while
+// walking the items of a bucket.
+const gcPageSize = 1000
+
+var (
+	gcDryRun = gcCommand.Flag(
+		"dry-run", "Report the cache artefacts that would be removed and the bytes that would be freed, without removing anything.",
+	).Bool()
+
+	retentionMaxAge = app.Flag(
+		"retention.max-age", "The maximum age of a cache artefact, older cache artefacts are removed by the gc command and the background sweeper, 0 keeps them forever ($TURBO_RETENTION_MAX_AGE).",
+	).Envar("TURBO_RETENTION_MAX_AGE").Default("0s").Duration()
+
+	retentionTeamMaxAge = app.Flag(
+		"retention.team-max-age", "The maximum age of the cache artefacts of a team, in the form of team=duration (repeatable).",
+	).StringMap()
+
+	retentionInterval = app.Flag(
+		"retention.interval", "How often the background sweeper removes cache artefacts older than the maximum age, 0 disables the sweeper ($TURBO_RETENTION_INTERVAL).",
+	).Envar("TURBO_RETENTION_INTERVAL").Default("0s").Duration()
+)
+
+// teamMaxAge holds the durations passed via --retention.team-max-age.
+var teamMaxAge = make(map[string]time.Duration)
+
+// initRetention parses the maximum age of the teams.
+func initRetention() error {
+	for teamID, value := range *retentionTeamMaxAge {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge < 0 {
+			return fmt.Errorf("invalid maximum age '%s' for team '%s'", value, teamID)
+		}
+		teamMaxAge[teamID] = maxAge
+	}
+	return nil
+}
+
+// getMaxAge returns the maximum age of the cache artefacts of the team, a
+// maximum age of 0 means the cache artefacts are kept forever.
+func getMaxAge(teamID string) time.Duration {
+	if maxAge, ok := teamMaxAge[teamID]; ok {
+		return maxAge
+	}
+	return *retentionMaxAge
+}
+
+func isRetentionEnabled() bool {
+	if *retentionMaxAge > 0 {
+		return true
+	}
+
+	for _, maxAge := range teamMaxAge {
+		if maxAge > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// gcTarget is a bucket that is walked by the garbage collection, the team is
+// empty when the bucket is shared and the team is derived from the path.
+type gcTarget struct {
+	Bucket string
+	TeamID string
+}
+
+// getGCTargets returns the buckets that contain cache artefacts. When a bucket
+// is used per team, the buckets can't be mapped back to a team, so the buckets
+// of the teams that are known to the server are returned. When a maximum age
+// applies to all teams and --bucket-template starts with a fixed prefix, the
+// buckets with that prefix are listed as well, those use the maximum age
+// passed via --retention.max-age.
+func getGCTargets(location stow.Location) ([]gcTarget, error) {
+	if !*enableBucketPerTeam {
+		return []gcTarget{{Bucket: *bucketName}}, nil
+	}
+
+	var teamIDs []string
+	for teamID := range teamMaxAge {
+		teamIDs = append(teamIDs, teamID)
+	}
+	if configuredAccounts != nil {
+		for _, team := range configuredAccounts.Teams {
+			teamIDs = append(teamIDs, team.ID, team.Slug)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var targets []gcTarget
+	for _, teamID := range teamIDs {
+		team, err := resolveTenant(teamID)
+		if err != nil || seen[team.Bucket] {
+			continue
+		}
+		seen[team.Bucket] = true
+		targets = append(targets, gcTarget{Bucket: team.Bucket, TeamID: teamID})
+	}
+
+	// The buckets of teams that made a request since the server started
+	for _, name := range containers.Names() {
+		if !seen[name] {
+			seen[name] = true
+			targets = append(targets, gcTarget{Bucket: name})
+		}
+	}
+
+	if *retentionMaxAge <= 0 {
+		return targets, nil
+	}
+
+	// Other buckets of the account may have names the template could have
+	// generated, so only a fixed prefix tells the buckets of the teams apart
+	pattern, ok := bucketNamePattern()
+	if !ok {
+		if len(teamIDs) == 0 {
+			return nil, errors.New("the buckets of the teams can't be told apart from other buckets as --bucket-template has no fixed prefix, use a template such as turbo-{{.Hash}} or pass the teams via --accounts.config or --retention.team-max-age")
+		}
+		logger.Log("message", "only checking the buckets of the known teams, as --bucket-template has no fixed prefix", "template", *bucketTemplate)
+		return targets, nil
+	}
+
+	cursor := stow.CursorStart
+	for {
+		buckets, next, err := location.Containers("", cursor, gcPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the buckets: %w", err)
+		}
+
+		for _, bucket := range buckets {
+			name := bucket.Name()
+			if !seen[name] && pattern.MatchString(name) {
+				seen[name] = true
+				targets = append(targets, gcTarget{Bucket: name})
+			}
+		}
+
+		if stow.IsCursorEnd(next) {
+			break
+		}
+		cursor = next
+	}
+
+	return targets, nil
+}
+
+// gcResult summarises a garbage collection run.
+type gcResult struct {
+	Scanned    int
+	Removed    int
+	BytesFreed int64
+}
+
+// expiredArtifact is a cache artefact that is older than the maximum age.
+type expiredArtifact struct {
+	teamID string
+	name   string
+	size   int64
+	age    time.Duration
+}
+
+// collectGarbage removes the cache artefacts that are older than the maximum
+// age of their team, nothing is removed when dryRun is true.
+func collectGarbage(now time.Time, dryRun bool) (gcResult, error) {
+	var result gcResult
+
+	location, err := getLocation()
+	if err != nil {
+		return result, err
+	}
+
+	targets, err := getGCTargets(location)
+	if err != nil {
+		return result, err
+	}
+
+	for _, target := range targets {
+		// Buckets are never created by the garbage collection
+		container, err := location.Container(target.Bucket)
+		if err == stow.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get bucket '%s': %w", target.Bucket, err)
+		}
+		container = instrumentContainer(container)
+		if tier != nil {
+			container = tier.Wrap(container)
+		}
+
+		if err := collectBucketGarbage(container, target, now, dryRun, &result); err != nil {
+			return result, fmt.Errorf("failed to collect garbage of bucket '%s': %w", target.Bucket, err)
+		}
+	}
+
+	return result, nil
+}
+
+func collectBucketGarbage(container stow.Container, target gcTarget, now time.Time, dryRun bool, result *gcResult) error {
+	// All pages are walked before removing anything, not every storage
+	// provider supports removing items while paginating
+	var expired []expiredArtifact
+
+	cursor := stow.CursorStart
+	for {
+		items, next, err := container.Items("", cursor, gcPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			result.Scanned++
+
+			name := item.Name()
+			// The buckets of unknown teams use the maximum age of all teams
+			teamID := target.TeamID
+			if teamID == "" && !*enableBucketPerTeam {
+				parts := strings.SplitN(name, "/", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				teamID = parts[0]
+			}
+
+			maxAge := getMaxAge(teamID)
+			if maxAge <= 0 {
+				continue
+			}
+
+			lastMod, err := item.LastMod()
+			if err != nil {
+				logger.Log("message", "failed to determine the age of cache item", "bucket", target.Bucket, "name", name, "error", err)
+				continue
+			}
+
+			age := now.Sub(lastMod)
+			if age <= maxAge {
+				continue
+			}
+
+			size, err := item.Size()
+			if err != nil {
+				size = 0
+			}
+			expired = append(expired, expiredArtifact{teamID: teamID, name: name, size: size, age: age})
+		}
+
+		if stow.IsCursorEnd(next) {
+			break
+		}
+		cursor = next
+	}
+
+	for _, artifact := range expired {
+		if dryRun {
+			logger.Log("message", "would remove expired cache item", "bucket", target.Bucket, "teamID", artifact.teamID, "name", artifact.name, "size", artifact.size, "age", artifact.age.Round(time.Second))
+		} else {
+			if err := container.RemoveItem(artifact.name); err != nil && err != stow.ErrNotFound {
+				logger.Log("message", "failed to remove expired cache item", "bucket", target.Bucket, "name", artifact.name, "error", err)
+				continue
+			}
+			forgetArtifact(target.Bucket, artifact.name)
+			logger.Log("message", "removed expired cache item", "bucket", target.Bucket, "teamID", artifact.teamID, "name", artifact.name, "size", artifact.size, "age", artifact.age.Round(time.Second))
+		}
+
+		result.Removed++
+		result.BytesFreed += artifact.size
+	}
+
+	return nil
+}
+
+// runGarbageCollection is the gc command.
+func runGarbageCollection() error {
+	if !isRetentionEnabled() {
+		return fmt.Errorf("no maximum age is configured, use --retention.max-age or --retention.team-max-age")
+	}
+
+	result, err := collectGarbage(time.Now(), *gcDryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "Removed"
+	if *gcDryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d of %d cache artefacts, freeing %d bytes\n", verb, result.Removed, result.Scanned, result.BytesFreed)
+	return nil
+}
+
+// startRetentionSweeper periodically removes the expired cache artefacts in
+// the background when --retention.interval is given, the returned function
+// stops the sweeper.
+func startRetentionSweeper() func() {
+	if *retentionInterval <= 0 || !isRetentionEnabled() {
+		return func() {}
+	}
+
+	logger.Log("message", "started the retention sweeper", "interval", *retentionInterval)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(*retentionInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				result, err := collectGarbage(now, false)
+				if err != nil {
+					logger.Log("message", "failed to remove expired cache items", "error", err)
+					continue
+				}
+				logger.Log("message", "removed expired cache items", "scanned", result.Scanned, "removed", result.Removed, "bytesFreed", result.BytesFreed)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
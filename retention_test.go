@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGetGCTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		teams    []accountTeam
+		want     []string
+		fails    bool
+	}{
+		{
+			name:     "template without prefix and unknown teams",
+			template: "{{.Hash}}",
+			fails:    true,
+		},
+		{
+			name:     "template without prefix and known teams",
+			template: "{{.Hash}}",
+			teams:    []accountTeam{{ID: "team_blah", Slug: "blah"}},
+			want:     []string{"cb779dfe54965ffcbebb543476a24440"},
+		},
+		{
+			name:     "template with prefix",
+			template: "turbo-{{.Slug}}",
+			want:     []string{"turbo-blah", "turbo-other"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTenancy(t, "local", true, test.template)
+
+			previousMaxAge, previousAccounts := *retentionMaxAge, configuredAccounts
+			t.Cleanup(func() {
+				*retentionMaxAge, configuredAccounts = previousMaxAge, previousAccounts
+			})
+			*retentionMaxAge = 24 * time.Hour
+			configuredAccounts = nil
+			if test.teams != nil {
+				configuredAccounts = &accounts{Teams: test.teams}
+			}
+
+			// An unrelated bucket that looks like the bucket of a team
+			// when only the hash is used
+			for _, name := range []string{"turbo-blah", "turbo-other", "0123456789abcdef0123456789abcdef", "unrelated"} {
+				if err := os.MkdirAll(filepath.Join(*localStoragePath, name), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			location, err := getLocation()
+			if err != nil {
+				t.Fatalf("getLocation() failed: %v", err)
+			}
+
+			targets, err := getGCTargets(location)
+			if test.fails {
+				if err == nil {
+					t.Errorf("getGCTargets() returned %+v, want an error", targets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getGCTargets() failed: %v", err)
+			}
+
+			var buckets []string
+			for _, target := range targets {
+				buckets = append(buckets, target.Bucket)
+			}
+			sort.Strings(buckets)
+
+			if len(buckets) != len(test.want) {
+				t.Fatalf("got buckets %q, want %q", buckets, test.want)
+			}
+			for i := range buckets {
+				if buckets[i] != test.want[i] {
+					t.Errorf("got buckets %q, want %q", buckets, test.want)
+				}
+			}
+		})
+	}
+}
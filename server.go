@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	serverReadHeaderTimeout = app.Flag(
+		"server.read-header-timeout", "The maximum duration for reading the headers of a request ($TURBO_SERVER_READ_HEADER_TIMEOUT).",
+	).Envar("TURBO_SERVER_READ_HEADER_TIMEOUT").Default("10s").Duration()
+
+	serverReadTimeout = app.Flag(
+		"server.read-timeout", "The maximum duration for reading a request including the body, this limits how long an upload can take, 0 disables the timeout ($TURBO_SERVER_READ_TIMEOUT).",
+	).Envar("TURBO_SERVER_READ_TIMEOUT").Default("5m").Duration()
+
+	serverWriteTimeout = app.Flag(
+		"server.write-timeout", "The maximum duration for writing a response, this limits how long a download can take, 0 disables the timeout ($TURBO_SERVER_WRITE_TIMEOUT).",
+	).Envar("TURBO_SERVER_WRITE_TIMEOUT").Default("5m").Duration()
+
+	serverIdleTimeout = app.Flag(
+		"server.idle-timeout", "How long an idle keep-alive connection is kept open ($TURBO_SERVER_IDLE_TIMEOUT).",
+	).Envar("TURBO_SERVER_IDLE_TIMEOUT").Default("2m").Duration()
+
+	serverMaxHeaderSize = app.Flag(
+		"server.max-header-size", "The maximum size of the headers of a request ($TURBO_SERVER_MAX_HEADER_SIZE).",
+	).Envar("TURBO_SERVER_MAX_HEADER_SIZE").Default("1MB").Bytes()
+
+	serverShutdownTimeout = app.Flag(
+		"server.shutdown-timeout", "How long in-flight requests are given to finish after receiving SIGTERM, before the connections are closed ($TURBO_SERVER_SHUTDOWN_TIMEOUT).",
+	).Envar("TURBO_SERVER_SHUTDOWN_TIMEOUT").Default("25s").Duration()
+)
+
+// newServer returns the HTTP server configured via the --server.* arguments.
+func newServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: *serverReadHeaderTimeout,
+		ReadTimeout:       *serverReadTimeout,
+		WriteTimeout:      *serverWriteTimeout,
+		IdleTimeout:       *serverIdleTimeout,
+		MaxHeaderBytes:    int(*serverMaxHeaderSize),
+	}
+}
+
+// runServer serves requests until SIGTERM or SIGINT is received, afterwards
+// no new connections are accepted and the in-flight requests are given
+// --server.shutdown-timeout to finish. An error is only returned when the
+// server fails to start.
+func runServer(server *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		// The certificate is provided by the TLS configuration
+		if server.TLSConfig != nil {
+			errs <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logger.Log("message", "shutting down the server", "signal", sig, "timeout", *serverShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *serverShutdownTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Log("message", "closing the connections of the requests that didn't finish in time", "error", err)
+		server.Close()
+		return nil
+	}
+
+	logger.Log("message", "finished the in-flight requests", "duration", time.Since(start))
+	return nil
+}
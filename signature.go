@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	gohash "hash"
+	"net/url"
+)
+
+var (
+	enableSignatureVerification = app.Flag(
+		"signature.enable", "Only accept cache artefacts that are signed by turbo with the secret of the team ($TURBO_SIGNATURE_VERIFICATION).",
+	).Envar("TURBO_SIGNATURE_VERIFICATION").Bool()
+
+	signatureKey = app.Flag(
+		"signature.key", "The secret used to verify the signature of cache artefacts of teams without their own secret ($TURBO_REMOTE_CACHE_SIGNATURE_KEY).",
+	).Envar("TURBO_REMOTE_CACHE_SIGNATURE_KEY").String()
+
+	signatureTeamKeys = app.Flag(
+		"signature.team-key", "The secret used to verify the signature of cache artefacts of a team, in the form of team=secret (repeatable).",
+	).StringMap()
+)
+
+// artifactSignatureMetadata is the data turbo prepends to the contents of the
+// cache artefact when generating the signature, the order of the fields
+// matters as the JSON encoded value is signed.
+type artifactSignatureMetadata struct {
+	Hash   string `json:"hash"`
+	TeamID string `json:"teamId"`
+}
+
+// signatureTeamID returns the team turbo signs cache artefacts with, the
+// teamId parameter. Turbo doesn't sign with the slug, so it's empty when only
+// the slug is sent.
+func signatureTeamID(query url.Values) string {
+	return query.Get("teamId")
+}
+
+// getSignatureKey returns the secret to verify the signatures of the cache
+// artefacts of the given team.
+func getSignatureKey(teamID string) ([]byte, bool) {
+	if key, ok := (*signatureTeamKeys)[teamID]; ok && key != "" {
+		return []byte(key), true
+	}
+
+	if *signatureKey != "" {
+		return []byte(*signatureKey), true
+	}
+
+	return nil, false
+}
+
+// newArtifactSignature returns a hash that calculates the signature of a
+// cache artefact in the same way as turbo does, a HMAC-SHA256 of the hash,
+// team and contents. The contents need to be written to the returned hash.
+func newArtifactSignature(key []byte, hash string, teamID string) (gohash.Hash, error) {
+	metadata, err := json.Marshal(artifactSignatureMetadata{Hash: hash, TeamID: teamID})
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(metadata)
+
+	return mac, nil
+}
+
+// verifyArtifactSignature checks whether the received tag, the base64 encoded
+// signature, matches the signature calculated by the hash.
+func verifyArtifactSignature(signature gohash.Hash, tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	receivedSignature, err := base64.StdEncoding.DecodeString(tag)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(receivedSignature, signature.Sum(nil))
+}
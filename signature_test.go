@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// The signature turbo sends in the x-artifact-tag header for the contents
+// "turbo cache artefact" with hash c5a7c0a1b2b3f9a6, team team_blah and the
+// secret my-secret: the base64 encoded HMAC-SHA256 of
+// {"hash":"c5a7c0a1b2b3f9a6","teamId":"team_blah"} followed by the contents.
+const turboArtifactTag = "Pqz8RXidLqmOAcq+v8Q+4BYSla/ycrwD9DfjFemhOB8="
+
+// setupSignatures enables the signature verification for a test, the previous
+// values are restored when the test finishes.
+func setupSignatures(t *testing.T, key string, teamKeys map[string]string) {
+	t.Helper()
+
+	previousEnabled, previousKey, previousTeamKeys := *enableSignatureVerification, *signatureKey, *signatureTeamKeys
+	t.Cleanup(func() {
+		*enableSignatureVerification, *signatureKey, *signatureTeamKeys = previousEnabled, previousKey, previousTeamKeys
+	})
+
+	*enableSignatureVerification = true
+	*signatureKey = key
+	*signatureTeamKeys = teamKeys
+}
+
+func TestArtifactSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		hash   string
+		teamID string
+		tag    string
+		valid  bool
+	}{
+		{name: "turbo", key: "my-secret", hash: "c5a7c0a1b2b3f9a6", teamID: "team_blah", tag: turboArtifactTag, valid: true},
+		{name: "other secret", key: "other-secret", hash: "c5a7c0a1b2b3f9a6", teamID: "team_blah", tag: turboArtifactTag},
+		{name: "other hash", key: "my-secret", hash: "c5a7c0a1b2b3f9a7", teamID: "team_blah", tag: turboArtifactTag},
+		{name: "slug", key: "my-secret", hash: "c5a7c0a1b2b3f9a6", teamID: "blah", tag: turboArtifactTag},
+		{name: "missing", key: "my-secret", hash: "c5a7c0a1b2b3f9a6", teamID: "team_blah", tag: ""},
+		{name: "not base64", key: "my-secret", hash: "c5a7c0a1b2b3f9a6", teamID: "team_blah", tag: "not base64!"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signature, err := newArtifactSignature([]byte(test.key), test.hash, test.teamID)
+			if err != nil {
+				t.Fatalf("newArtifactSignature() failed: %v", err)
+			}
+			signature.Write([]byte("turbo cache artefact"))
+
+			if valid := verifyArtifactSignature(signature, test.tag); valid != test.valid {
+				t.Errorf("verifyArtifactSignature() = %v, want %v", valid, test.valid)
+			}
+		})
+	}
+}
+
+func TestSignedUploadWithSlug(t *testing.T) {
+	setupTenancy(t, "memory", false, "{{.Hash}}")
+	setupQuota(t, 0, quotaPolicyReject)
+
+	// The secret of the team must be used even though the bucket is
+	// resolved with the slug
+	setupSignatures(t, "other-secret", map[string]string{"team_blah": "my-secret"})
+
+	tests := []struct {
+		name  string
+		query string
+		tag   string
+		code  int
+	}{
+		{name: "team id and slug", query: "teamId=team_blah&slug=blah", tag: turboArtifactTag, code: http.StatusAccepted},
+		{name: "team id", query: "teamId=team_blah", tag: turboArtifactTag, code: http.StatusAccepted},
+		{name: "invalid signature", query: "teamId=team_blah&slug=blah", tag: "Aqz8RXidLqmOAcq+v8Q+4BYSla/ycrwD9DfjFemhOB8=", code: http.StatusForbidden},
+		{name: "slug", query: "slug=blah", tag: turboArtifactTag, code: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/v8/artifacts/c5a7c0a1b2b3f9a6?"+test.query, strings.NewReader("turbo cache artefact"))
+			req = mux.SetURLVars(req, map[string]string{"artificateId": "c5a7c0a1b2b3f9a6"})
+			req.Header.Set("x-artifact-tag", test.tag)
+			res := httptest.NewRecorder()
+
+			writeCacheItem(res, req)
+
+			if res.Code != test.code {
+				t.Errorf("upload returned %d, want %d: %s", res.Code, test.code, res.Body)
+			}
+		})
+	}
+}
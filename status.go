@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	cacheStatusEnabled   = "enabled"
+	cacheStatusDisabled  = "disabled"
+	cacheStatusOverLimit = "over_limit"
+	cacheStatusPaused    = "paused"
+)
+
+var (
+	cacheStatus = app.Flag(
+		"cache-status", "The status of the remote cache reported to turbo: enabled, disabled or paused ($TURBO_CACHE_STATUS).",
+	).Envar("TURBO_CACHE_STATUS").Default(cacheStatusEnabled).Enum(cacheStatusEnabled, cacheStatusDisabled, cacheStatusPaused)
+
+	teamCacheStatus = app.Flag(
+		"team-cache-status", "The status of the remote cache for a team, in the form of team=status (repeatable).",
+	).StringMap()
+)
+
+// validateTeamCacheStatus checks whether the statuses passed via
+// --team-cache-status are known to turbo.
+func validateTeamCacheStatus() error {
+	for teamID, status := range *teamCacheStatus {
+		switch status {
+		case cacheStatusEnabled, cacheStatusDisabled, cacheStatusPaused:
+		default:
+			return fmt.Errorf("invalid cache status '%s' for team '%s'", status, teamID)
+		}
+	}
+	return nil
+}
+
+// getCacheStatus returns the status of the remote cache for the given team,
+// the server wide status takes precedence over the status of the team.
+func getCacheStatus(teamID string) string {
+	if *cacheStatus != cacheStatusEnabled {
+		return *cacheStatus
+	}
+
+	if status, ok := (*teamCacheStatus)[teamID]; ok && status != cacheStatusEnabled {
+		return status
+	}
+
+	if team, err := resolveTenant(teamID); err == nil && quotas.IsOverLimit(team) {
+		return cacheStatusOverLimit
+	}
+
+	return cacheStatusEnabled
+}
+
+// isCacheReadable returns whether cache artefacts can be read by the team,
+// reading is still allowed when a team is over its storage quota.
+func isCacheReadable(teamID string) bool {
+	status := getCacheStatus(teamID)
+	return status == cacheStatusEnabled || status == cacheStatusOverLimit
+}
+
+// writeCacheStatusError responds with the error turbo expects when uploading
+// to a remote cache that isn't enabled.
+func writeCacheStatusError(w http.ResponseWriter, status string) {
+	code := "remote_caching_disabled"
+	if status == cacheStatusOverLimit {
+		code = cacheStatusOverLimit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(fmt.Sprintf(`{"error":{"message":"remote caching is %s","code":"%s"}}`, status, code)))
+}
+
+// readCacheStatus tells turbo whether remote caching is available for a team.
+func readCacheStatus(w http.ResponseWriter, r *http.Request) {
+	logger.Log("message", "readCacheStatus()")
+
+	query := r.URL.Query()
+	status := getCacheStatus(getTeamID(query))
+
+	response, err := json.Marshal(struct {
+		Status string `json:"status"`
+	}{Status: status})
+	if err != nil {
+		logger.Log("message", "failed to encode the cache status", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/graymeta/stow"
+)
+
+// storageLocation is the connection to the storage provider, it's created
+// once and shared by all requests.
+var storageLocation = struct {
+	sync.Mutex
+	location stow.Location
+}{}
+
+// getLocation returns the connection to the storage provider selected via
+// --kind, the connection is only made on first use.
+func getLocation() (stow.Location, error) {
+	storageLocation.Lock()
+	defer storageLocation.Unlock()
+
+	if storageLocation.location != nil {
+		return storageLocation.location, nil
+	}
+
+	config, err := getProviderConfig(*kind)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := stow.Dial(*kind, config)
+	if err != nil {
+		return nil, err
+	}
+
+	storageLocation.location = location
+	return location, nil
+}
+
+// closeLocation closes the connection to the storage provider.
+func closeLocation() error {
+	storageLocation.Lock()
+	defer storageLocation.Unlock()
+
+	if storageLocation.location == nil {
+		return nil
+	}
+
+	err := storageLocation.location.Close()
+	storageLocation.location = nil
+	return err
+}
+
+// containerCache keeps the containers that have been looked up or created,
+// a container is forgotten when an operation on it shows it no longer exists.
+type containerCache struct {
+	mu         sync.RWMutex
+	containers map[string]stow.Container
+}
+
+func newContainerCache() *containerCache {
+	return &containerCache{containers: make(map[string]stow.Container)}
+}
+
+// Get returns the container with the given name, the container is created
+// when it doesn't exist yet.
+func (c *containerCache) Get(location stow.Location, name string) (stow.Container, error) {
+	c.mu.RLock()
+	container, ok := c.containers[name]
+	c.mu.RUnlock()
+	if ok {
+		return container, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another request might have created the container while waiting for the lock
+	if container, ok := c.containers[name]; ok {
+		return container, nil
+	}
+
+	container, err := location.Container(name)
+	if err == stow.ErrNotFound {
+		container, err = location.CreateContainer(name)
+		if err != nil {
+			logger.Log("message", "failed to create container", "bucket", name, "error", err)
+			return nil, err
+		}
+
+		logger.Log("message", "created the container for storing cache items", "bucket", name)
+	} else if err != nil {
+		// Creating the container could fail as well or, worse, replace it,
+		// when the lookup failed for another reason, e.g. a network error
+		logger.Log("message", "failed to fetch existing container with the requested name", "bucket", name, "error", err)
+		return nil, err
+	}
+
+	container = &cachedContainer{Container: container, cache: c, name: name}
+	c.containers[name] = container
+	return container, nil
+}
+
+// Forget removes the container from the cache, the next Get looks it up again
+// and creates it when it no longer exists.
+func (c *containerCache) Forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.containers, name)
+}
+
+// Names returns the names of the containers that have been looked up or
+// created.
+func (c *containerCache) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.containers))
+	for name := range c.containers {
+		names = append(names, name)
+	}
+	return names
+}
+
+var containers = newContainerCache()
+
+// cachedContainer forgets the container in the cache when an operation fails
+// because it doesn't exist, e.g. after the bucket was deleted.
+type cachedContainer struct {
+	stow.Container
+	cache *containerCache
+	name  string
+}
+
+// isNotFound returns whether the error reports a missing container or item,
+// the local provider returns the error of the file system.
+func isNotFound(err error) bool {
+	return err == stow.ErrNotFound || os.IsNotExist(err)
+}
+
+// forgetOn forgets the container when the error is a not found error.
+func (c *cachedContainer) forgetOn(err error) {
+	if err != nil && isNotFound(err) {
+		logger.Log("message", "forgetting the container as it might no longer exist", "bucket", c.name, "error", err)
+		c.cache.Forget(c.name)
+	}
+}
+
+func (c *cachedContainer) Item(id string) (stow.Item, error) {
+	item, err := c.Container.Item(id)
+	c.forgetOn(err)
+	return item, err
+}
+
+func (c *cachedContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	items, next, err := c.Container.Items(prefix, cursor, count)
+	c.forgetOn(err)
+	return items, next, err
+}
+
+func (c *cachedContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	item, err := c.Container.Put(name, r, size, metadata)
+	if err != nil {
+		// The providers don't report a missing container on writes as
+		// stow.ErrNotFound, so the container is looked up again after any
+		// failed write
+		logger.Log("message", "forgetting the container after a failed write", "bucket", c.name, "error", err)
+		c.cache.Forget(c.name)
+	}
+	return item, err
+}
+
+func (c *cachedContainer) RemoveItem(id string) error {
+	err := c.Container.RemoveItem(id)
+	c.forgetOn(err)
+	return err
+}
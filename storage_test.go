@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/graymeta/stow"
+
+	"tapico-turborepo-remote-cache/local"
+)
+
+// failingLocation is a storage provider of which the containers can't be
+// looked up, e.g. because of a network error.
+type failingLocation struct {
+	stow.Location
+	created bool
+}
+
+func (l *failingLocation) Container(id string) (stow.Container, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (l *failingLocation) CreateContainer(name string) (stow.Container, error) {
+	l.created = true
+	return nil, errors.New("unexpected CreateContainer() call")
+}
+
+func TestContainerCacheLookupFailure(t *testing.T) {
+	previousLogger := logger
+	t.Cleanup(func() { logger = previousLogger })
+	logger = log.NewNopLogger()
+
+	location := &failingLocation{}
+	if _, err := newContainerCache().Get(location, "team_blah"); err == nil || err.Error() != "connection refused" {
+		t.Errorf("Get() returned %v, want the lookup error", err)
+	}
+	if location.created {
+		t.Errorf("Get() created the container after the lookup failed")
+	}
+}
+
+func TestContainerCacheDeletedContainer(t *testing.T) {
+	previousLogger := logger
+	t.Cleanup(func() { logger = previousLogger })
+	logger = log.NewNopLogger()
+
+	root := t.TempDir()
+	location, err := stow.Dial(local.Kind, stow.ConfigMap{local.ConfigKeyPath: root})
+	if err != nil {
+		t.Fatalf("stow.Dial() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		location.Close()
+	})
+
+	cache := newContainerCache()
+	container, err := cache.Get(location, "team_blah")
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if _, err := container.Put("abc", strings.NewReader("contents"), 8, nil); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	// The bucket is deleted while the service is running
+	if err := os.RemoveAll(filepath.Join(root, "team_blah")); err != nil {
+		t.Fatalf("os.RemoveAll() failed: %v", err)
+	}
+	if _, err := container.Item("abc"); !isNotFound(err) {
+		t.Fatalf("Item() returned %v, want a not found error", err)
+	}
+	if names := cache.Names(); len(names) != 0 {
+		t.Errorf("Names() returned %q after the container was deleted, want none", names)
+	}
+
+	container, err = cache.Get(location, "team_blah")
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if _, err := container.Put("abc", strings.NewReader("contents"), 8, nil); err != nil {
+		t.Fatalf("Put() after the container was created again failed: %v", err)
+	}
+	if _, err := container.Item("abc"); err != nil {
+		t.Errorf("Item() returned %v, want the cache artefact", err)
+	}
+}
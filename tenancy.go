@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var (
+	bucketTemplate = app.Flag(
+		"bucket-template", "The template of the bucket name of a team when --enable-bucket-per-team is enabled, {{.TeamID}}, {{.Slug}} and {{.Hash}} can be used ($BUCKET_TEMPLATE).",
+	).Envar("BUCKET_TEMPLATE").Default("{{.Hash}}").String()
+)
+
+// tenant describes where the cache artefacts of a team are stored. When a
+// bucket is used per team, the artefacts are stored in the root of the bucket
+// of the team, otherwise the bucket is shared and the team id is used as
+// directory.
+type tenant struct {
+	TeamID string
+	Bucket string
+	Prefix string
+}
+
+// ArtefactPath returns the path of the cache artefact inside the bucket.
+func (t tenant) ArtefactPath(name string) string {
+	return t.Prefix + name
+}
+
+// bucketTemplateData is available in the template passed via --bucket-template.
+type bucketTemplateData struct {
+	// TeamID is the team id or slug as received from turbo.
+	TeamID string
+	// Slug is the team id converted to lowercase letters, numbers and dashes.
+	Slug string
+	// Hash is the MD5 hash of the team id.
+	Hash string
+}
+
+var parsedBucketTemplate *template.Template
+
+// initTenancy parses the template used for the bucket names of the teams.
+func initTenancy() error {
+	tmpl, err := template.New("bucket").Option("missingkey=error").Parse(*bucketTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid bucket template: %w", err)
+	}
+
+	parsedBucketTemplate = tmpl
+	return nil
+}
+
+var invalidSlugCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// bucketNamePattern returns a pattern matching the bucket names generated by
+// --bucket-template, so the buckets of teams that aren't known to the server
+// can be found by listing the buckets. ok is false when the template doesn't
+// start with a fixed prefix, e.g. {{.Hash}}, as the buckets of the teams
+// can't be told apart from other buckets of the account then.
+func bucketNamePattern() (pattern *regexp.Regexp, ok bool) {
+	const marker = "\x00"
+	placeholders := map[string]string{
+		"teamid": `.+`,
+		"slug":   `[a-z0-9-]+`,
+		"hash":   `[0-9a-f]{32}`,
+	}
+
+	var name bytes.Buffer
+	err := parsedBucketTemplate.Execute(&name, bucketTemplateData{
+		TeamID: marker + "teamid" + marker,
+		Slug:   marker + "slug" + marker,
+		Hash:   marker + "hash" + marker,
+	})
+	if err != nil {
+		return nil, false
+	}
+
+	// The parts alternate between literal text and placeholders
+	var expression strings.Builder
+	parts := strings.Split(name.String(), marker)
+	for i, part := range parts {
+		if i%2 == 0 {
+			expression.WriteString(regexp.QuoteMeta(part))
+			continue
+		}
+		expression.WriteString(placeholders[part])
+	}
+	ok = parts[0] != ""
+
+	pattern, err = regexp.Compile("^" + expression.String() + "$")
+	if err != nil {
+		return nil, false
+	}
+	return pattern, ok
+}
+
+// isSafePathSegment returns whether the value received from turbo can be used
+// as a single segment of a path, without referring to another directory.
+func isSafePathSegment(value string) bool {
+	return value != "" && value != "." && !strings.Contains(value, "..") && !strings.ContainsAny(value, `/\`)
+}
+
+// canonicalTeamID returns the id of the team when a team defined via
+// --accounts.config is referred to by its slug, so the id and the slug of a
+// team share the cache artefacts and the quota.
+func canonicalTeamID(teamID string) string {
+	if configuredAccounts != nil {
+		if team, ok := configuredAccounts.findTeam(teamID); ok {
+			return team.ID
+		}
+	}
+	return teamID
+}
+
+// resolveTenant returns where the cache artefacts of the given team are
+// stored, an error is returned when the team id can't be used safely.
+func resolveTenant(teamID string) (tenant, error) {
+	teamID = canonicalTeamID(teamID)
+	if !isSafePathSegment(teamID) {
+		return tenant{}, fmt.Errorf("invalid team id '%s'", teamID)
+	}
+
+	if !*enableBucketPerTeam {
+		return tenant{
+			TeamID: teamID,
+			Bucket: *bucketName,
+			Prefix: teamID + "/",
+		}, nil
+	}
+
+	hash := md5.Sum([]byte(teamID))
+	data := bucketTemplateData{
+		TeamID: teamID,
+		Slug:   strings.Trim(invalidSlugCharacters.ReplaceAllString(strings.ToLower(teamID), "-"), "-"),
+		Hash:   hex.EncodeToString(hash[:]),
+	}
+
+	var name bytes.Buffer
+	if err := parsedBucketTemplate.Execute(&name, data); err != nil {
+		return tenant{}, fmt.Errorf("failed to generate bucket name for team '%s': %w", teamID, err)
+	}
+
+	if err := validateBucketName(*kind, name.String()); err != nil {
+		return tenant{}, err
+	}
+
+	return tenant{
+		TeamID: teamID,
+		Bucket: name.String(),
+	}, nil
+}
+
+var (
+	s3BucketName  = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	gcsBucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,61}[a-z0-9]$`)
+
+	azureContainerName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+)
+
+// validateBucketName checks whether the name is accepted as bucket name by
+// the given kind of storage provider.
+func validateBucketName(kind string, name string) error {
+	switch kind {
+	case "s3":
+		if !s3BucketName.MatchString(name) || strings.Contains(name, "..") || net.ParseIP(name) != nil {
+			return fmt.Errorf("invalid Amazon S3 bucket name '%s', must be 3-63 lowercase letters, numbers, dots or dashes", name)
+		}
+	case "gcs":
+		if !gcsBucketName.MatchString(name) || strings.HasPrefix(name, "goog") || strings.Contains(name, "google") {
+			return fmt.Errorf("invalid Google Cloud Storage bucket name '%s', must be 3-63 lowercase letters, numbers, dots, dashes or underscores", name)
+		}
+	case "azure":
+		if !azureContainerName.MatchString(name) || strings.Contains(name, "--") {
+			return fmt.Errorf("invalid Azure Blob Storage container name '%s', must be 3-63 lowercase letters, numbers or single dashes", name)
+		}
+	case "local":
+		if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid directory name '%s' for local storage", name)
+		}
+	default:
+		if name == "" {
+			return fmt.Errorf("the bucket name can't be empty")
+		}
+	}
+
+	return nil
+}
+
+// writeInvalidTeamError responds with an error when the team id received from
+// turbo can't be mapped to a bucket.
+func writeInvalidTeamError(w http.ResponseWriter, err error) {
+	message, _ := json.Marshal(err.Error())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(fmt.Sprintf(`{"error":{"message":%s,"code":"bad_request"}}`, message)))
+}
+
+// writeInvalidHashError responds with the error for a hash of a cache artefact
+// that can't be used as its path.
+func writeInvalidHashError(w http.ResponseWriter, hash string) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error": map[string]string{
+			"message": fmt.Sprintf("invalid hash '%s'", hash),
+			"code":    "bad_request",
+		},
+	})
+}
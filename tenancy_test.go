@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+// setupTenancy configures the storage provider and tenancy flags for a test,
+// the previous values are restored when the test finishes.
+func setupTenancy(t *testing.T, storageKind string, perTeam bool, template string) {
+	t.Helper()
+
+	previousLogger := logger
+	previousKind, previousPerTeam, previousBucket := *kind, *enableBucketPerTeam, *bucketName
+	previousTemplate, previousPath := *bucketTemplate, *localStoragePath
+	t.Cleanup(func() {
+		closeLocation()
+		containers = newContainerCache()
+
+		logger = previousLogger
+		*kind, *enableBucketPerTeam, *bucketName = previousKind, previousPerTeam, previousBucket
+		*bucketTemplate, *localStoragePath = previousTemplate, previousPath
+	})
+
+	logger = log.NewNopLogger()
+	*kind = storageKind
+	*enableBucketPerTeam = perTeam
+	*bucketName = "tapico-remote-cache"
+	*bucketTemplate = template
+	*localStoragePath = t.TempDir()
+
+	// Every test starts with an empty storage provider
+	closeLocation()
+	containers = newContainerCache()
+
+	if err := initTenancy(); err != nil {
+		t.Fatalf("initTenancy() failed: %v", err)
+	}
+}
+
+func TestResolveTenantSharedBucket(t *testing.T) {
+	setupTenancy(t, "s3", false, "{{.Hash}}")
+
+	team, err := resolveTenant("team_blah")
+	if err != nil {
+		t.Fatalf("resolveTenant() failed: %v", err)
+	}
+
+	if team.Bucket != "tapico-remote-cache" || team.Prefix != "team_blah/" {
+		t.Errorf("got bucket %q and prefix %q, want the shared bucket with prefix team_blah/", team.Bucket, team.Prefix)
+	}
+	if path := team.ArtefactPath("abc"); path != "team_blah/abc" {
+		t.Errorf("got artefact path %q, want team_blah/abc", path)
+	}
+}
+
+func TestResolveTenantBucketPerTeam(t *testing.T) {
+	hash := md5.Sum([]byte("Team_Blah"))
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{{.Hash}}", hex.EncodeToString(hash[:])},
+		{"turbo-{{.Slug}}", "turbo-team-blah"},
+		{"turbo-{{.TeamID}}", "turbo-Team_Blah"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			setupTenancy(t, "local", true, test.template)
+
+			team, err := resolveTenant("Team_Blah")
+			if err != nil {
+				t.Fatalf("resolveTenant() failed: %v", err)
+			}
+
+			if team.Bucket != test.want || team.Prefix != "" {
+				t.Errorf("got bucket %q and prefix %q, want bucket %q without prefix", team.Bucket, team.Prefix, test.want)
+			}
+			if path := team.ArtefactPath("abc"); path != "abc" {
+				t.Errorf("got artefact path %q, want abc", path)
+			}
+		})
+	}
+}
+
+func TestResolveTenantRejectsInvalidTeams(t *testing.T) {
+	for _, perTeam := range []bool{false, true} {
+		setupTenancy(t, "local", perTeam, "{{.TeamID}}")
+
+		for _, teamID := range []string{"", ".", "..", "../team_other", "team/other", `team\other`} {
+			if team, err := resolveTenant(teamID); err == nil {
+				t.Errorf("resolveTenant(%q) with bucket per team %v returned %+v, want an error", teamID, perTeam, team)
+			}
+		}
+	}
+}
+
+func TestResolveTenantRejectsInvalidBucketNames(t *testing.T) {
+	setupTenancy(t, "s3", true, "{{.TeamID}}")
+
+	if team, err := resolveTenant("Team_Blah"); err == nil {
+		t.Errorf("resolveTenant() returned %+v, want an error as S3 bucket names are lowercase", team)
+	}
+}
+
+func TestValidateBucketName(t *testing.T) {
+	tests := []struct {
+		kind  string
+		name  string
+		valid bool
+	}{
+		{"s3", "tapico-remote-cache", true},
+		{"s3", "tapico.remote.cache", true},
+		{"s3", "ab", false},
+		{"s3", strings.Repeat("a", 64), false},
+		{"s3", "Tapico", false},
+		{"s3", "tapico_cache", false},
+		{"s3", "tapico..cache", false},
+		{"s3", "-tapico", false},
+		{"s3", "192.168.1.1", false},
+
+		{"gcs", "tapico-remote-cache", true},
+		{"gcs", "tapico_remote_cache", true},
+		{"gcs", "ab", false},
+		{"gcs", "Tapico", false},
+		{"gcs", "goog-cache", false},
+		{"gcs", "my-google-cache", false},
+
+		{"azure", "tapico-remote-cache", true},
+		{"azure", "ab", false},
+		{"azure", "tapico--cache", false},
+		{"azure", "tapico.cache", false},
+		{"azure", "tapico_cache", false},
+		{"azure", "Tapico", false},
+
+		{"local", "tapico-remote-cache", true},
+		{"local", "Team_Blah", true},
+		{"local", "", false},
+		{"local", ".hidden", false},
+		{"local", "..", false},
+		{"local", "team/other", false},
+		{"local", `team\other`, false},
+	}
+
+	for _, test := range tests {
+		err := validateBucketName(test.kind, test.name)
+		if test.valid && err != nil {
+			t.Errorf("validateBucketName(%q, %q) failed: %v", test.kind, test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("validateBucketName(%q, %q) succeeded, want an error", test.kind, test.name)
+		}
+	}
+}
+
+// getTestStorageKinds returns the storage providers the cache artefacts are
+// stored in by the tests. The cloud storage providers are only used when
+// listed in TURBO_TEST_STORAGE, e.g. TURBO_TEST_STORAGE=s3,gcs,azure, as they
+// need the emulators of the docker compose file in the dev directory.
+func getTestStorageKinds() []string {
+	kinds := []string{"memory", "local"}
+	for _, kind := range strings.Split(os.Getenv("TURBO_TEST_STORAGE"), ",") {
+		if kind = strings.TrimSpace(kind); kind != "" && kind != "memory" && kind != "local" {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// getTestEnv returns the environment variable, or the value for the emulators
+// of the docker compose file when it's not set.
+func getTestEnv(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// setupCloudStorage connects the cloud storage provider to its emulator for a
+// test, the environment variables of the server can be used to connect to
+// another endpoint. The previous values are restored when the test finishes.
+func setupCloudStorage(t *testing.T, storageKind string) {
+	t.Helper()
+
+	previousEndpoint, previousAccessKeyID, previousSecretKey, previousRegion := *awsEndpoint, *awsAccessKeyID, *awsSecretKey, *awsRegionName
+	previousProjectID, previousCredentials := *googleProjectID, *googleCredentialsJSON
+	previousConnectionString := *azureConnectionString
+	t.Cleanup(func() {
+		*awsEndpoint, *awsAccessKeyID, *awsSecretKey, *awsRegionName = previousEndpoint, previousAccessKeyID, previousSecretKey, previousRegion
+		*googleProjectID, *googleCredentialsJSON = previousProjectID, previousCredentials
+		*azureConnectionString = previousConnectionString
+	})
+
+	switch storageKind {
+	case "s3":
+		*awsEndpoint = getTestEnv("AWS_ENDPOINT", "http://127.0.0.1:9000")
+		*awsAccessKeyID = getTestEnv("AWS_ACCESS_KEY_ID", "minio")
+		*awsSecretKey = getTestEnv("AWS_SECRET_ACCESS_KEY", "miniosecretkey")
+		*awsRegionName = getTestEnv("AWS_S3_REGION_NAME", "eu-west-1")
+	case "gcs":
+		if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+			t.Setenv("STORAGE_EMULATOR_HOST", "http://127.0.0.1:9100")
+		}
+		*googleProjectID = getTestEnv("GOOGLE_PROJECT_ID", "test")
+		*googleCredentialsJSON = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	case "azure":
+		*azureConnectionString = getTestEnv("AZURE_STORAGE_CONNECTION_STRING", "UseDevelopmentStorage=true")
+	}
+}
+
+func TestCacheBlobRoundTrip(t *testing.T) {
+	for _, storageKind := range getTestStorageKinds() {
+		for _, perTeam := range []bool{false, true} {
+			name := storageKind + "/shared"
+			if perTeam {
+				name = storageKind + "/bucket-per-team"
+			}
+
+			// The slugs of the teams contain underscores, which aren't
+			// allowed in the bucket names of every cloud storage provider
+			template := "turbo-{{.Slug}}"
+			if storageKind != "memory" && storageKind != "local" {
+				template = "turbo-{{.Hash}}"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				setupTenancy(t, storageKind, perTeam, template)
+				setupCloudStorage(t, storageKind)
+
+				teamA, err := resolveTenant("team_a")
+				if err != nil {
+					t.Fatalf("resolveTenant() failed: %v", err)
+				}
+				teamB, err := resolveTenant("team_b")
+				if err != nil {
+					t.Fatalf("resolveTenant() failed: %v", err)
+				}
+
+				ctx := context.Background()
+				contents := "the cache artefact"
+				_, path, err := createCacheBlob(ctx, "abc", teamA, strings.NewReader(contents), int64(len(contents)), nil)
+				if err != nil {
+					t.Fatalf("createCacheBlob() failed: %v", err)
+				}
+				if path != teamA.ArtefactPath("abc") {
+					t.Errorf("got path %q, want %q", path, teamA.ArtefactPath("abc"))
+				}
+
+				item, err := readCacheBlob(ctx, "abc", teamA)
+				if err != nil {
+					t.Fatalf("readCacheBlob() failed: %v", err)
+				}
+
+				reader, err := item.Open()
+				if err != nil {
+					t.Fatalf("Open() failed: %v", err)
+				}
+				defer reader.Close()
+
+				read, err := ioutil.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("reading the item failed: %v", err)
+				}
+				if string(read) != contents {
+					t.Errorf("got contents %q, want %q", read, contents)
+				}
+
+				// The cache artefacts of a team are not visible to other teams
+				if _, err := readCacheBlob(ctx, "abc", teamB); err == nil {
+					t.Errorf("readCacheBlob() of another team succeeded, want an error")
+				}
+			})
+		}
+	}
+}
+
+func TestBucketNamePattern(t *testing.T) {
+	tests := []struct {
+		template string
+		ok       bool
+		matches  []string
+		others   []string
+	}{
+		{"turbo-{{.Slug}}", true, []string{"turbo-team-blah"}, []string{"tapico-remote-cache", "turbo-"}},
+		{"turbo.{{.Hash}}", true, []string{"turbo.0123456789abcdef0123456789abcdef"}, []string{"turboX0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef"}},
+		{"{{.Hash}}", false, nil, nil},
+		{"{{.Hash}}-turbo", false, nil, nil},
+		{"{{.TeamID}}", false, nil, nil},
+		{"{{.Slug}}", false, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			setupTenancy(t, "local", true, test.template)
+
+			pattern, ok := bucketNamePattern()
+			if ok != test.ok {
+				t.Fatalf("got ok %v, want %v", ok, test.ok)
+			}
+			for _, name := range test.matches {
+				if !pattern.MatchString(name) {
+					t.Errorf("%q doesn't match %s", name, pattern)
+				}
+			}
+			for _, name := range test.others {
+				if pattern.MatchString(name) {
+					t.Errorf("%q matches %s", name, pattern)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+
+	"tapico-turborepo-remote-cache/local"
+)
+
+var (
+	tierLocalPath = app.Flag(
+		"tier.local-path", "Enables a local disk cache in front of the storage provider, the path to store the cache artefacts in ($TURBO_TIER_LOCAL_PATH).",
+	).Envar("TURBO_TIER_LOCAL_PATH").String()
+
+	tierLocalMaxSize = app.Flag(
+		"tier.local-max-size", "The maximum size of the local disk cache, the least recently used cache artefacts are removed when exceeded ($TURBO_TIER_LOCAL_MAX_SIZE).",
+	).Envar("TURBO_TIER_LOCAL_MAX_SIZE").Default("10GB").Bytes()
+)
+
+// tierEntry is a cache artefact stored in the local tier.
+type tierEntry struct {
+	bucket string
+	name   string
+	size   int64
+}
+
+// localTier is a size bounded cache on the local disk, the cache artefacts
+// are stored in the same layout as the `local` storage provider.
+type localTier struct {
+	location   stow.Location
+	containers *containerCache
+	maxSize    int64
+	spoolDir   string
+
+	mu         sync.Mutex
+	size       int64
+	lru        *list.List
+	entries    map[string]*list.Element
+	populating map[string]bool
+}
+
+// tier is the local tier in front of the storage provider, nil when disabled.
+var tier *localTier
+
+// initLocalTier sets up the local tier when --tier.local-path is given.
+func initLocalTier() error {
+	if *tierLocalPath == "" {
+		return nil
+	}
+
+	path, err := filepath.Abs(*tierLocalPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path, 0777); err != nil {
+		return err
+	}
+
+	location, err := stow.Dial(local.Kind, stow.ConfigMap{local.ConfigKeyPath: path})
+	if err != nil {
+		return err
+	}
+
+	// Cache artefacts that were being written when the server stopped are
+	// incomplete
+	spoolDir := filepath.Join(path, ".populating")
+	if err := os.RemoveAll(spoolDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(spoolDir, 0777); err != nil {
+		return err
+	}
+
+	t := &localTier{
+		location:   location,
+		containers: newContainerCache(),
+		maxSize:    int64(*tierLocalMaxSize),
+		spoolDir:   spoolDir,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		populating: make(map[string]bool),
+	}
+
+	if err := t.load(path); err != nil {
+		return err
+	}
+
+	logger.Log("message", "enabled local tier", "path", path, "size", t.size, "maxSize", t.maxSize)
+	tier = t
+	return nil
+}
+
+// load indexes the cache artefacts that are already stored on disk, the
+// modification time is used to determine the least recently used artefacts.
+func (t *localTier) load(root string) error {
+	type loadedEntry struct {
+		entry   *tierEntry
+		modTime time.Time
+	}
+
+	var loaded []loadedEntry
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
+		if len(parts) != 2 {
+			return nil
+		}
+
+		loaded = append(loaded, loadedEntry{
+			entry:   &tierEntry{bucket: parts[0], name: parts[1], size: info.Size()},
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Insert from the oldest to the newest so the newest ends up in front
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].modTime.Before(loaded[j].modTime)
+	})
+	for _, l := range loaded {
+		t.add(l.entry)
+	}
+
+	t.evict()
+	return nil
+}
+
+func tierKey(bucket string, name string) string {
+	return bucket + "/" + name
+}
+
+// add registers a cache artefact stored in the local tier, the caller must
+// hold the lock when the tier is in use.
+func (t *localTier) add(entry *tierEntry) {
+	key := tierKey(entry.bucket, entry.name)
+	if element, ok := t.entries[key]; ok {
+		t.size -= element.Value.(*tierEntry).size
+		t.lru.Remove(element)
+	}
+
+	t.entries[key] = t.lru.PushFront(entry)
+	t.size += entry.size
+}
+
+// evict removes the least recently used cache artefacts until the local tier
+// is within its maximum size, the caller must hold the lock.
+func (t *localTier) evict() {
+	for t.size > t.maxSize && t.lru.Len() > 0 {
+		element := t.lru.Back()
+		entry := element.Value.(*tierEntry)
+
+		t.lru.Remove(element)
+		delete(t.entries, tierKey(entry.bucket, entry.name))
+		t.size -= entry.size
+
+		container, err := t.containers.Get(t.location, entry.bucket)
+		if err == nil {
+			err = container.RemoveItem(entry.name)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			logger.Log("message", "failed to evict cache item from local tier", "bucket", entry.bucket, "name", entry.name, "error", err)
+		}
+	}
+}
+
+// touch marks the cache artefact as recently used.
+func (t *localTier) touch(bucket string, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if element, ok := t.entries[tierKey(bucket, name)]; ok {
+		t.lru.MoveToFront(element)
+	}
+}
+
+// remove forgets about a cache artefact that was removed from the local tier.
+func (t *localTier) remove(bucket string, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tierKey(bucket, name)
+	if element, ok := t.entries[key]; ok {
+		t.size -= element.Value.(*tierEntry).size
+		t.lru.Remove(element)
+		delete(t.entries, key)
+	}
+}
+
+// tee returns a reader that writes the cache artefact to the local tier while
+// it's served from the storage provider, so it's only downloaded once. The
+// returned function must be called with whether the whole cache artefact was
+// read, only then it's added to the tier. It's only used after a cache
+// artefact has been downloaded, checking for or querying cache artefacts
+// doesn't populate the tier, as that would download every cache artefact
+// turbo asks about.
+func (t *localTier) tee(bucket string, name string, item stow.Item, r io.Reader) (io.Reader, func(complete bool)) {
+	skip := func(bool) {}
+
+	size, err := item.Size()
+	if err != nil || size < 0 || size > t.maxSize {
+		return r, skip
+	}
+
+	key := tierKey(bucket, name)
+
+	t.mu.Lock()
+	if _, ok := t.entries[key]; ok || t.populating[key] {
+		t.mu.Unlock()
+		return r, skip
+	}
+	t.populating[key] = true
+	t.mu.Unlock()
+
+	done := func() {
+		t.mu.Lock()
+		delete(t.populating, key)
+		t.mu.Unlock()
+	}
+
+	file, err := ioutil.TempFile(t.spoolDir, "item-")
+	if err != nil {
+		logger.Log("message", "failed to populate local tier", "bucket", bucket, "name", name, "error", err)
+		done()
+		return r, skip
+	}
+
+	writer := &tierWriter{file: file}
+	return io.TeeReader(r, writer), func(complete bool) {
+		go func() {
+			defer done()
+			defer os.Remove(file.Name())
+			defer file.Close()
+
+			if writer.err != nil {
+				logger.Log("message", "failed to populate local tier", "bucket", bucket, "name", name, "error", writer.err)
+				return
+			}
+			if !complete || writer.written != size {
+				return
+			}
+
+			if err := t.store(bucket, name, item, file, size); err != nil {
+				logger.Log("message", "failed to populate local tier", "bucket", bucket, "name", name, "error", err)
+			}
+		}()
+	}
+}
+
+// tierWriter writes the served cache artefact to a temporary file, failing
+// to write it doesn't interrupt serving the cache artefact.
+type tierWriter struct {
+	file    *os.File
+	written int64
+	err     error
+}
+
+func (w *tierWriter) Write(b []byte) (int, error) {
+	if w.err == nil {
+		n, err := w.file.Write(b)
+		w.written += int64(n)
+		w.err = err
+	}
+	return len(b), nil
+}
+
+// store adds the cache artefact written to the file to the local tier.
+func (t *localTier) store(bucket string, name string, item stow.Item, file *os.File, size int64) error {
+	container, err := t.containers.Get(t.location, bucket)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Only the metadata stored by this server is copied, backends return
+	// additional metadata that isn't a string
+	metadata := make(map[string]interface{})
+	if itemMetadata, err := item.Metadata(); err == nil {
+		for key, value := range itemMetadata {
+			if str, ok := value.(string); ok {
+				metadata[key] = str
+			}
+		}
+	}
+
+	if _, err := container.Put(name, file, size, metadata); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.add(&tierEntry{bucket: bucket, name: name, size: size})
+	t.evict()
+	t.mu.Unlock()
+	return nil
+}
+
+// tierItem is a cache artefact stored in the local tier. It's opened while the
+// tier is locked, so it can't be evicted in between, an open file can still
+// be read after it's removed. When it was removed before it was opened, the
+// cache artefact is read from the storage provider instead.
+type tierItem struct {
+	stow.Item
+	tier     *localTier
+	fallback func() (stow.Item, error)
+}
+
+func (i *tierItem) Open() (io.ReadCloser, error) {
+	i.tier.mu.Lock()
+	contents, err := i.Item.Open()
+	i.tier.mu.Unlock()
+	if err == nil {
+		return contents, nil
+	}
+
+	item, err := i.fallback()
+	if err != nil {
+		return nil, err
+	}
+	return item.Open()
+}
+
+// Wrap returns a container that serves cache artefacts from the local tier
+// when possible, and falls back to the given container of the storage
+// provider.
+func (t *localTier) Wrap(container stow.Container) stow.Container {
+	return &tieredContainer{Container: container, tier: t}
+}
+
+// tieredContainer checks the local tier before asking the storage provider,
+// all writes go to the storage provider as it's the source of truth.
+type tieredContainer struct {
+	stow.Container
+	tier *localTier
+}
+
+func (c *tieredContainer) Item(id string) (stow.Item, error) {
+	bucket := c.Container.Name()
+
+	if localContainer, err := c.tier.containers.Get(c.tier.location, bucket); err == nil {
+		// The metadata is read while the tier is locked, as the metadata of
+		// an evicted cache artefact is removed as well
+		c.tier.mu.Lock()
+		item, err := localContainer.Item(id)
+		if err == nil {
+			_, err = item.Metadata()
+		}
+		c.tier.mu.Unlock()
+
+		if err == nil {
+			c.tier.touch(bucket, id)
+			return &tierItem{Item: item, tier: c.tier, fallback: func() (stow.Item, error) {
+				return c.Container.Item(id)
+			}}, nil
+		}
+	}
+
+	return c.Container.Item(id)
+}
+
+func (c *tieredContainer) RemoveItem(id string) error {
+	bucket := c.Container.Name()
+
+	if localContainer, err := c.tier.containers.Get(c.tier.location, bucket); err == nil {
+		if err := localContainer.RemoveItem(id); err == nil {
+			c.tier.remove(bucket, id)
+		}
+	}
+
+	return c.Container.RemoveItem(id)
+}
+
+func (c *tieredContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	bucket := c.Container.Name()
+
+	// Remove a stale copy from the local tier, it's populated again on read
+	if localContainer, err := c.tier.containers.Get(c.tier.location, bucket); err == nil {
+		if err := localContainer.RemoveItem(name); err == nil {
+			c.tier.remove(bucket, name)
+		}
+	}
+
+	return c.Container.Put(name, r, size, metadata)
+}
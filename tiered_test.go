@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/graymeta/stow"
+
+	"tapico-turborepo-remote-cache/local"
+)
+
+// setupLocalTier enables the local tier in front of a container of the
+// `local` storage provider, which stands in for the storage provider. It
+// returns the container of the storage provider and the tiered container.
+func setupLocalTier(t *testing.T, maxSize int64) (stow.Container, stow.Container) {
+	t.Helper()
+
+	previousLogger, previousTier := logger, tier
+	previousPath := *tierLocalPath
+	t.Cleanup(func() {
+		logger, tier = previousLogger, previousTier
+		*tierLocalPath = previousPath
+	})
+
+	logger = log.NewNopLogger()
+	*tierLocalPath = t.TempDir()
+
+	if err := initLocalTier(); err != nil {
+		t.Fatalf("initLocalTier() failed: %v", err)
+	}
+	tier.maxSize = maxSize
+
+	location, err := stow.Dial(local.Kind, stow.ConfigMap{local.ConfigKeyPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("stow.Dial() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		location.Close()
+	})
+
+	container, err := location.CreateContainer("tapico-remote-cache")
+	if err != nil {
+		t.Fatalf("CreateContainer() failed: %v", err)
+	}
+	return container, tier.Wrap(container)
+}
+
+// waitForTier waits until the cache artefact is no longer written to the
+// local tier.
+func waitForTier(t *testing.T, name string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		tier.mu.Lock()
+		populating := tier.populating[tierKey("tapico-remote-cache", name)]
+		tier.mu.Unlock()
+
+		if !populating {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is still written to the local tier", name)
+}
+
+func TestTierTee(t *testing.T) {
+	tests := []struct {
+		name     string
+		read     int
+		complete bool
+		stored   bool
+	}{
+		{name: "complete", read: -1, complete: true, stored: true},
+		{name: "incomplete", read: 4, complete: false, stored: false},
+		{name: "failed", read: -1, complete: false, stored: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, container := setupLocalTier(t, 1024)
+			contents := "cache artefact"
+
+			item, err := provider.Put("abc", strings.NewReader(contents), int64(len(contents)), nil)
+			if err != nil {
+				t.Fatalf("Put() failed: %v", err)
+			}
+
+			r, populated := tier.tee("tapico-remote-cache", "abc", item, strings.NewReader(contents))
+			buf := make([]byte, len(contents))
+			if test.read >= 0 {
+				buf = buf[:test.read]
+			}
+			if _, err := r.Read(buf); err != nil {
+				t.Fatalf("Read() failed: %v", err)
+			}
+			populated(test.complete)
+			waitForTier(t, "abc")
+
+			tier.mu.Lock()
+			_, stored := tier.entries[tierKey("tapico-remote-cache", "abc")]
+			tier.mu.Unlock()
+			if stored != test.stored {
+				t.Fatalf("stored in local tier = %v, want %v", stored, test.stored)
+			}
+			if !stored {
+				return
+			}
+
+			// The cache artefact is now served from the local tier
+			if err := provider.RemoveItem("abc"); err != nil {
+				t.Fatalf("RemoveItem() failed: %v", err)
+			}
+			tiered, err := container.Item("abc")
+			if err != nil {
+				t.Fatalf("Item() failed: %v", err)
+			}
+			assertContents(t, tiered, contents)
+		})
+	}
+}
+
+func TestTierItemSurvivesEviction(t *testing.T) {
+	provider, container := setupLocalTier(t, 1024)
+	contents := "cache artefact"
+
+	item, err := provider.Put("abc", strings.NewReader(contents), int64(len(contents)), nil)
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	r, populated := tier.tee("tapico-remote-cache", "abc", item, strings.NewReader(contents))
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	populated(true)
+	waitForTier(t, "abc")
+
+	tiered, err := container.Item("abc")
+	if err != nil {
+		t.Fatalf("Item() failed: %v", err)
+	}
+	if _, ok := tiered.(*tierItem); !ok {
+		t.Fatalf("Item() returned %T, want it from the local tier", tiered)
+	}
+	opened, err := tiered.Open()
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer opened.Close()
+
+	// Evict everything while the cache artefact is being served
+	tier.mu.Lock()
+	tier.maxSize = 0
+	tier.evict()
+	tier.mu.Unlock()
+
+	read, err := ioutil.ReadAll(opened)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if string(read) != contents {
+		t.Errorf("read %q, want %q", read, contents)
+	}
+
+	// Opening it after the eviction falls back to the storage provider
+	assertContents(t, tiered, contents)
+}
+
+func assertContents(t *testing.T, item stow.Item, want string) {
+	t.Helper()
+
+	contents, err := item.Open()
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer contents.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(contents); err != nil {
+		t.Fatalf("ReadFrom() failed: %v", err)
+	}
+	if buf.String() != want {
+		t.Errorf("read %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+const (
+	clientAuthTokenOrCert  = "token-or-cert"
+	clientAuthCert         = "cert"
+	clientAuthCertAndToken = "cert-and-token"
+)
+
+var (
+	tlsCertPath = app.Flag(
+		"tls.cert", "The path of the PEM encoded certificate to serve HTTPS, the certificate is reloaded when the file changes ($TURBO_TLS_CERT).",
+	).Envar("TURBO_TLS_CERT").String()
+
+	tlsKeyPath = app.Flag(
+		"tls.key", "The path of the PEM encoded private key of the certificate passed via --tls.cert ($TURBO_TLS_KEY).",
+	).Envar("TURBO_TLS_KEY").String()
+
+	tlsClientCAPath = app.Flag(
+		"tls.client-ca", "The path of the PEM encoded bundle of certificate authorities to verify client certificates against, enables authentication with client certificates ($TURBO_TLS_CLIENT_CA).",
+	).Envar("TURBO_TLS_CLIENT_CA").String()
+
+	tlsClientAuth = app.Flag(
+		"tls.client-auth", "How a client authenticates when --tls.client-ca is given: with a token or a client certificate, only with a client certificate, or with a client certificate and a token ($TURBO_TLS_CLIENT_AUTH).",
+	).Envar("TURBO_TLS_CLIENT_AUTH").Default(clientAuthTokenOrCert).Enum(clientAuthTokenOrCert, clientAuthCert, clientAuthCertAndToken)
+
+	tlsReloadInterval = app.Flag(
+		"tls.reload-interval", "How often the files passed via --tls.cert, --tls.key and --tls.client-ca are checked for changes ($TURBO_TLS_RELOAD_INTERVAL).",
+	).Envar("TURBO_TLS_RELOAD_INTERVAL").Default("10s").Duration()
+)
+
+// certificateReloader holds the certificate and client certificate
+// authorities, so they can be replaced without restarting the server.
+type certificateReloader struct {
+	certPath string
+	keyPath  string
+	caPath   string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// load reads the files, the current certificate is kept when a file is
+// invalid, e.g. while the certificate has been replaced but the key not yet.
+func (c *certificateReloader) load() error {
+	certificate, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load the certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if c.caPath != "" {
+		bundle, err := ioutil.ReadFile(c.caPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the client certificate authorities: %w", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificates found in '%s'", c.caPath)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.certificate = &certificate
+	c.clientCAs = clientCAs
+	return nil
+}
+
+func (c *certificateReloader) reload() {
+	if err := c.load(); err != nil {
+		logger.Log("message", "failed to reload the TLS certificate, the previous certificate is still used", "error", err)
+		return
+	}
+	logger.Log("message", "reloaded the TLS certificate")
+}
+
+func (c *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.certificate, nil
+}
+
+// getConfigForClient returns the configuration with the current client
+// certificate authorities. Client certificates are only verified when they
+// are presented, the middleware decides whether a certificate is required, so
+// the health checks keep working without a certificate.
+func (c *certificateReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+		ClientAuth:     tls.VerifyClientCertIfGiven,
+		ClientCAs:      c.clientCAs,
+	}, nil
+}
+
+// initTLS returns the TLS configuration when --tls.cert is given, or nil when
+// the server is served over plain HTTP. The returned function stops watching
+// the files for changes.
+func initTLS() (*tls.Config, func(), error) {
+	if *tlsCertPath == "" && *tlsKeyPath == "" {
+		if *tlsClientCAPath != "" {
+			return nil, nil, errors.New("--tls.client-ca requires --tls.cert and --tls.key")
+		}
+		return nil, func() {}, nil
+	}
+
+	if *tlsCertPath == "" || *tlsKeyPath == "" {
+		return nil, nil, errors.New("both --tls.cert and --tls.key are required")
+	}
+
+	reloader := &certificateReloader{certPath: *tlsCertPath, keyPath: *tlsKeyPath, caPath: *tlsClientCAPath}
+	if err := reloader.load(); err != nil {
+		return nil, nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if *tlsClientCAPath != "" {
+		config.GetConfigForClient = reloader.getConfigForClient
+	}
+
+	paths := []string{*tlsCertPath, *tlsKeyPath}
+	if *tlsClientCAPath != "" {
+		paths = append(paths, *tlsClientCAPath)
+	}
+	stop := watchFiles(paths, *tlsReloadInterval, reloader.reload)
+
+	return config, stop, nil
+}
+
+// isClientCertAuthEnabled returns whether clients can authenticate with a
+// client certificate.
+func isClientCertAuthEnabled() bool {
+	return *tlsClientCAPath != ""
+}
+
+// hasVerifiedClientCert returns whether the request was made with a client
+// certificate that was issued by one of the certificate authorities passed
+// via --tls.client-ca.
+func hasVerifiedClientCert(req *http.Request) bool {
+	return isClientCertAuthEnabled() && req.TLS != nil && len(req.TLS.VerifiedChains) > 0
+}
+
+// authenticateClientCert combines the principal of the token, nil when no
+// valid token was passed, with the client certificate as configured via
+// --tls.client-auth. When both are required, the token determines the teams
+// and permissions.
+func authenticateClientCert(req *http.Request, who *principal) *principal {
+	if !isClientCertAuthEnabled() {
+		return who
+	}
+
+	var cert *principal
+	if hasVerifiedClientCert(req) {
+		cert = fullAccess("client certificate " + req.TLS.VerifiedChains[0][0].Subject.CommonName)
+	}
+
+	switch *tlsClientAuth {
+	case clientAuthCert:
+		return cert
+	case clientAuthCertAndToken:
+		if cert == nil {
+			return nil
+		}
+		return who
+	}
+
+	if who != nil {
+		return who
+	}
+	return cert
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/argon2"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	hashAlgorithmSHA256   = "sha256"
+	hashAlgorithmArgon2ID = "argon2id"
+)
+
+var (
+	tokensFile = app.Flag(
+		"tokens.file", "The path to the YAML or JSON file defining the accepted tokens, together with the teams and permissions of each token ($TURBO_TOKENS_FILE).",
+	).Envar("TURBO_TOKENS_FILE").String()
+
+	tokensReloadInterval = app.Flag(
+		"tokens.reload-interval", "How often the file passed via --tokens.file is checked for changes, the file is also reloaded on SIGHUP ($TURBO_TOKENS_RELOAD_INTERVAL).",
+	).Envar("TURBO_TOKENS_RELOAD_INTERVAL").Default("10s").Duration()
+
+	hashTokenAlgorithm = hashTokenCommand.Flag(
+		"algorithm", "The algorithm to hash the token with, sha256 is sufficient for randomly generated tokens.",
+	).Default(hashAlgorithmSHA256).Enum(hashAlgorithmSHA256, hashAlgorithmArgon2ID)
+)
+
+// The parameters of the argon2id hashes created by the hash-token command.
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+)
+
+// tokenGrant is a token defined in the file passed via --tokens.file.
+type tokenGrant struct {
+	// Name identifies the token in the logs.
+	Name string `yaml:"name"`
+	// Token is the token in plain text, prefer Hash so the file doesn't
+	// contain the secret.
+	Token string `yaml:"token"`
+	// Hash is the hash of the token, either sha256:<hex> or an argon2id hash
+	// in the $argon2id$v=19$m=...,t=...,p=...$<salt>$<hash> format.
+	Hash string `yaml:"hash"`
+	// Teams are the ids or slugs of the teams the token has access to, "*"
+	// gives access to all teams.
+	Teams []string `yaml:"teams"`
+	// Permissions are read, write or both.
+	Permissions []string `yaml:"permissions"`
+}
+
+// argon2Hash is a parsed argon2id hash.
+type argon2Hash struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2Hash parses a hash in the format of the reference implementation.
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != hashAlgorithmArgon2ID {
+		return nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version '%s'", parts[2])
+	}
+
+	parsed := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.time, &parsed.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2 parameters '%s'", parts[3])
+	}
+
+	var err error
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.New("invalid argon2 salt")
+	}
+	if parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(parsed.key) == 0 {
+		return nil, errors.New("invalid argon2 hash")
+	}
+
+	return parsed, nil
+}
+
+func (h *argon2Hash) matches(token string) bool {
+	key := argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// argon2Checks limits the number of argon2id hashes verified at once, every
+// check takes the memory set in the hash, so requests with unknown tokens
+// can't exhaust the memory and CPU of the server.
+var argon2Checks = make(chan struct{}, runtime.NumCPU())
+
+// storedToken is a token of the token file, only the hash is kept in memory.
+type storedToken struct {
+	who    *principal
+	digest []byte
+	argon2 *argon2Hash
+}
+
+// tokenStore holds the tokens of the file passed via --tokens.file.
+type tokenStore struct {
+	tokens []storedToken
+
+	// verified remembers the SHA-256 digests of the tokens that matched an
+	// argon2id hash, as verifying those is slow on purpose.
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]*principal
+}
+
+// tokens holds the tokens of the file, the store is replaced when the file is
+// reloaded.
+var tokens = struct {
+	sync.RWMutex
+	store *tokenStore
+}{}
+
+// loadTokens reads the token file, as JSON is valid YAML both formats are
+// read the same way.
+func loadTokens(path string) (*tokenStore, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Tokens []tokenGrant `yaml:"tokens"`
+	}
+	if err := yaml.UnmarshalStrict(contents, &config); err != nil {
+		return nil, err
+	}
+
+	store := &tokenStore{verified: make(map[[sha256.Size]byte]*principal)}
+	names := make(map[string]bool)
+	digests := make(map[string]string)
+	for i, grant := range config.Tokens {
+		if grant.Name == "" {
+			grant.Name = fmt.Sprintf("token #%d", i+1)
+		}
+		if names[grant.Name] {
+			return nil, fmt.Errorf("%s is defined more than once", grant.Name)
+		}
+		names[grant.Name] = true
+
+		if len(grant.Teams) == 0 {
+			return nil, fmt.Errorf("%s has no teams, use \"*\" for all teams", grant.Name)
+		}
+		if len(grant.Permissions) == 0 {
+			return nil, fmt.Errorf("%s has no permissions", grant.Name)
+		}
+
+		who := &principal{Name: grant.Name, Teams: grant.Teams}
+		for _, permission := range grant.Permissions {
+			switch permission {
+			case permissionRead:
+				who.Read = true
+			case permissionWrite:
+				who.Write = true
+			default:
+				return nil, fmt.Errorf("%s has the unknown permission '%s'", grant.Name, permission)
+			}
+		}
+
+		stored := storedToken{who: who}
+		switch {
+		case grant.Token != "" && grant.Hash != "":
+			return nil, fmt.Errorf("%s has both a token and a hash", grant.Name)
+		case grant.Token != "":
+			digest := sha256.Sum256([]byte(grant.Token))
+			stored.digest = digest[:]
+		case strings.HasPrefix(grant.Hash, hashAlgorithmSHA256+":"):
+			digest, err := hex.DecodeString(strings.TrimPrefix(grant.Hash, hashAlgorithmSHA256+":"))
+			if err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("%s has an invalid sha256 hash", grant.Name)
+			}
+			stored.digest = digest
+		case grant.Hash != "":
+			hash, err := parseArgon2Hash(grant.Hash)
+			if err != nil {
+				return nil, fmt.Errorf("%s has an invalid hash: %w", grant.Name, err)
+			}
+			stored.argon2 = hash
+		default:
+			return nil, fmt.Errorf("%s has no token or hash", grant.Name)
+		}
+
+		if stored.digest != nil {
+			if other, ok := digests[string(stored.digest)]; ok {
+				return nil, fmt.Errorf("%s has the same token as %s", grant.Name, other)
+			}
+			digests[string(stored.digest)] = grant.Name
+		}
+
+		store.tokens = append(store.tokens, stored)
+	}
+
+	if len(store.tokens) == 0 {
+		return nil, errors.New("no tokens are defined")
+	}
+
+	return store, nil
+}
+
+// lookup returns the principal of the token. All the SHA-256 digests are
+// compared in constant time, so the time it takes doesn't reveal how much of
+// a token matched.
+func (s *tokenStore) lookup(token string) (*principal, bool) {
+	digest := sha256.Sum256([]byte(token))
+
+	var who *principal
+	for _, stored := range s.tokens {
+		if stored.digest != nil && subtle.ConstantTimeCompare(digest[:], stored.digest) == 1 {
+			who = stored.who
+		}
+	}
+	if who != nil {
+		return who, true
+	}
+
+	s.mu.Lock()
+	who, ok := s.verified[digest]
+	s.mu.Unlock()
+	if ok {
+		return who, true
+	}
+
+	for _, stored := range s.tokens {
+		if stored.argon2 == nil {
+			continue
+		}
+
+		argon2Checks <- struct{}{}
+		matched := stored.argon2.matches(token)
+		<-argon2Checks
+
+		if matched {
+			s.mu.Lock()
+			s.verified[digest] = stored.who
+			s.mu.Unlock()
+			return stored.who, true
+		}
+	}
+
+	return nil, false
+}
+
+// initTokens loads the tokens when --tokens.file is given.
+func initTokens() error {
+	if *tokensFile == "" {
+		return nil
+	}
+
+	store, err := loadTokens(*tokensFile)
+	if err != nil {
+		return err
+	}
+
+	tokens.Lock()
+	tokens.store = store
+	tokens.Unlock()
+	return nil
+}
+
+// reloadTokens replaces the tokens with the current contents of the file, the
+// previous tokens are kept when the file is invalid.
+func reloadTokens() {
+	if err := initTokens(); err != nil {
+		logger.Log("message", "failed to reload the tokens, the previous tokens are still accepted", "path", *tokensFile, "error", err)
+		return
+	}
+	logger.Log("message", "reloaded the tokens", "path", *tokensFile)
+}
+
+// watchTokens reloads the token file when it changes or on SIGHUP, the
+// returned function stops watching.
+func watchTokens() func() {
+	if *tokensFile == "" {
+		return func() {}
+	}
+
+	stopWatching := watchFiles([]string{*tokensFile}, *tokensReloadInterval, reloadTokens)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				reloadTokens()
+			}
+		}
+	}()
+
+	return func() {
+		stopWatching()
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// lookupToken returns the principal of a token defined in the token file.
+func lookupToken(token string) (*principal, bool) {
+	tokens.RLock()
+	store := tokens.store
+	tokens.RUnlock()
+
+	if store == nil {
+		return nil, false
+	}
+	return store.lookup(token)
+}
+
+// tokenID returns an identifier of the token that doesn't reveal it, the
+// first 8 bytes of its SHA-256 hash.
+func tokenID(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:8])
+}
+
+// isAllowedTurboToken returns whether the token was passed via --turbo-token,
+// the tokens are compared in constant time.
+func isAllowedTurboToken(token string) bool {
+	digest := sha256.Sum256([]byte(token))
+
+	accepted := false
+	for _, allowed := range strings.Split(*allowedTurboTokens, ",") {
+		if allowed == "" {
+			continue
+		}
+		allowedDigest := sha256.Sum256([]byte(allowed))
+		if subtle.ConstantTimeCompare(digest[:], allowedDigest[:]) == 1 {
+			accepted = true
+		}
+	}
+	return accepted
+}
+
+// hashToken returns the hash of the token in the format of the token file.
+func hashToken(token string, algorithm string) (string, error) {
+	if algorithm == hashAlgorithmSHA256 {
+		digest := sha256.Sum256([]byte(token))
+		return hashAlgorithmSHA256 + ":" + hex.EncodeToString(digest[:]), nil
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(token), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", hashAlgorithmArgon2ID, argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// runHashToken is the hash-token command, the token is read from the standard
+// input so it doesn't end up in the shell history.
+func runHashToken() error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return errors.New("no token was passed via the standard input")
+	}
+
+	token := strings.TrimRight(line, "\r\n")
+	if token == "" {
+		return errors.New("the token is empty")
+	}
+
+	hash, err := hashToken(token, *hashTokenAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(hash)
+	return nil
+}
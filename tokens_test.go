@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// setupTokens loads the token file for a test, the previous values are
+// restored when the test finishes.
+func setupTokens(t *testing.T, contents string) {
+	t.Helper()
+
+	previousFile, previousStore := *tokensFile, tokens.store
+	t.Cleanup(func() {
+		*tokensFile = previousFile
+		tokens.store = previousStore
+	})
+
+	*tokensFile = filepath.Join(t.TempDir(), "tokens.yaml")
+	writeTestFile(t, *tokensFile, []byte(contents))
+
+	if err := initTokens(); err != nil {
+		t.Fatalf("initTokens() failed: %v", err)
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{name: "valid", contents: `{"tokens": [{"name": "ci", "token": "secret", "teams": ["*"], "permissions": ["read"]}]}`},
+		{name: "no tokens", contents: `tokens: []`, err: "no tokens are defined"},
+		{name: "unknown field", contents: `{"tokens": [{"name": "ci", "token": "secret", "team": ["*"], "permissions": ["read"]}]}`, err: "not found"},
+		{name: "no teams", contents: `{"tokens": [{"name": "ci", "token": "secret", "permissions": ["read"]}]}`, err: "ci has no teams"},
+		{name: "no permissions", contents: `{"tokens": [{"name": "ci", "token": "secret", "teams": ["*"]}]}`, err: "ci has no permissions"},
+		{name: "unknown permission", contents: `{"tokens": [{"name": "ci", "token": "secret", "teams": ["*"], "permissions": ["admin"]}]}`, err: "unknown permission 'admin'"},
+		{name: "token and hash", contents: `{"tokens": [{"name": "ci", "token": "secret", "hash": "sha256:00", "teams": ["*"], "permissions": ["read"]}]}`, err: "both a token and a hash"},
+		{name: "no token", contents: `{"tokens": [{"name": "ci", "teams": ["*"], "permissions": ["read"]}]}`, err: "no token or hash"},
+		{name: "invalid sha256 hash", contents: `{"tokens": [{"name": "ci", "hash": "sha256:00", "teams": ["*"], "permissions": ["read"]}]}`, err: "invalid sha256 hash"},
+		{name: "invalid argon2 hash", contents: `{"tokens": [{"name": "ci", "hash": "$argon2id$v=19$m=65536$abc", "teams": ["*"], "permissions": ["read"]}]}`, err: "invalid hash"},
+		{
+			name:     "duplicate name",
+			contents: `{"tokens": [{"name": "ci", "token": "a", "teams": ["*"], "permissions": ["read"]}, {"name": "ci", "token": "b", "teams": ["*"], "permissions": ["read"]}]}`,
+			err:      "ci is defined more than once",
+		},
+		{
+			name:     "duplicate token",
+			contents: `{"tokens": [{"name": "ci", "token": "a", "teams": ["*"], "permissions": ["read"]}, {"name": "dev", "token": "a", "teams": ["*"], "permissions": ["read"]}]}`,
+			err:      "dev has the same token as ci",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.yaml")
+			writeTestFile(t, path, []byte(test.contents))
+
+			_, err := loadTokens(path)
+			if test.err == "" && err != nil {
+				t.Fatalf("loadTokens() failed: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("loadTokens() returned %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestTokenScoping(t *testing.T) {
+	previousAccounts := configuredAccounts
+	t.Cleanup(func() {
+		configuredAccounts = previousAccounts
+	})
+	configuredAccounts = &accounts{Teams: []accountTeam{{ID: "team_blah", Slug: "blah"}, {ID: "team_other", Slug: "other"}}}
+
+	developerHash, err := hashToken("developer-token", hashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("hashToken() failed: %v", err)
+	}
+	adminHash, err := hashToken("admin-token", hashAlgorithmArgon2ID)
+	if err != nil {
+		t.Fatalf("hashToken() failed: %v", err)
+	}
+
+	setupTokens(t, `
+tokens:
+  - name: ci
+    token: ci-token
+    teams: [team_blah]
+    permissions: [read, write]
+  - name: developer
+    hash: "`+developerHash+`"
+    teams: [blah]
+    permissions: [read]
+  - name: admin
+    hash: "`+adminHash+`"
+    teams: ["*"]
+    permissions: [write]
+`)
+
+	tests := []struct {
+		token      string
+		method     string
+		query      string
+		authorized bool
+	}{
+		{token: "ci-token", method: http.MethodGet, query: "teamId=team_blah", authorized: true},
+		{token: "ci-token", method: http.MethodPut, query: "teamId=team_blah", authorized: true},
+		{token: "ci-token", method: http.MethodGet, query: "slug=blah", authorized: true},
+		{token: "ci-token", method: http.MethodGet, query: "teamId=team_other"},
+		{token: "ci-token", method: http.MethodGet, query: "teamId=team_blah&slug=other"},
+		{token: "developer-token", method: http.MethodGet, query: "teamId=team_blah", authorized: true},
+		{token: "developer-token", method: http.MethodHead, query: "slug=blah", authorized: true},
+		{token: "developer-token", method: http.MethodPut, query: "teamId=team_blah"},
+		{token: "developer-token", method: http.MethodPost, query: "slug=blah"},
+		{token: "developer-token", method: http.MethodGet, query: "teamId=team_unknown"},
+		{token: "admin-token", method: http.MethodPut, query: "teamId=team_other", authorized: true},
+		{token: "admin-token", method: http.MethodPut, query: "teamId=team_unknown", authorized: true},
+		{token: "admin-token", method: http.MethodGet, query: "teamId=team_other"},
+	}
+
+	for _, test := range tests {
+		who, ok := lookupToken(test.token)
+		if !ok {
+			t.Fatalf("lookupToken() didn't find %s", test.token)
+		}
+
+		req := httptest.NewRequest(test.method, "/v8/artifacts/abc?"+test.query, nil)
+		req = mux.SetURLVars(req, map[string]string{"artificateId": "abc"})
+
+		if authorized := isAuthorized(req, who); authorized != test.authorized {
+			t.Errorf("%s %s with %s authorized: %v, want %v", test.method, test.query, who.Name, authorized, test.authorized)
+		}
+	}
+
+	for _, token := range []string{"", "unknown-token", "CI-TOKEN", "admin-token "} {
+		if who, ok := lookupToken(token); ok {
+			t.Errorf("lookupToken(%q) returned %s, want no principal", token, who.Name)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	stdout "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/graymeta/stow"
+)
+
+const (
+	tracingExporterNone     = "none"
+	tracingExporterStdout   = "stdout"
+	tracingExporterOTLPGRPC = "otlp-grpc"
+	tracingExporterOTLPHTTP = "otlp-http"
+)
+
+var (
+	tracingExporter = app.Flag(
+		"tracing.exporter", "Where to send the traces to: none, stdout, otlp-grpc or otlp-http ($TURBO_TRACING_EXPORTER).",
+	).Envar("TURBO_TRACING_EXPORTER").Default(tracingExporterNone).Enum(tracingExporterNone, tracingExporterStdout, tracingExporterOTLPGRPC, tracingExporterOTLPHTTP)
+
+	tracingEndpoint = app.Flag(
+		"tracing.endpoint", "The host and port of the OTLP collector, e.g. localhost:4317 for otlp-grpc or localhost:4318 for otlp-http, defaults to $OTEL_EXPORTER_OTLP_ENDPOINT ($TURBO_TRACING_ENDPOINT).",
+	).Envar("TURBO_TRACING_ENDPOINT").String()
+
+	tracingInsecure = app.Flag(
+		"tracing.insecure", "Connect to the OTLP collector without TLS ($TURBO_TRACING_INSECURE).",
+	).Envar("TURBO_TRACING_INSECURE").Bool()
+
+	tracingHeaders = app.Flag(
+		"tracing.header", "A header sent to the OTLP collector, in the form of name=value (repeatable).",
+	).StringMap()
+
+	tracingSampleRatio = app.Flag(
+		"tracing.sample-ratio", "The ratio of the traces that are sampled between 0 and 1, a trace started by the caller is sampled when the caller sampled it ($TURBO_TRACING_SAMPLE_RATIO).",
+	).Envar("TURBO_TRACING_SAMPLE_RATIO").Default("1").Float64()
+
+	tracingServiceName = app.Flag(
+		"tracing.service-name", "The name of the service reported in the traces ($TURBO_TRACING_SERVICE_NAME).",
+	).Envar("TURBO_TRACING_SERVICE_NAME").Default("tapico-remote-cache-service").String()
+
+	tracingResourceAttributes = app.Flag(
+		"tracing.resource-attribute", "An attribute added to the resource of the traces, in the form of key=value (repeatable), $OTEL_RESOURCE_ATTRIBUTES is also used.",
+	).StringMap()
+)
+
+// tracer creates the spans around the calls to the storage provider.
+var tracer = otel.Tracer("tapico-remote-cache")
+
+// newTraceExporter returns the exporter selected via --tracing.exporter, or nil
+// when the traces aren't exported.
+func newTraceExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	switch *tracingExporter {
+	case tracingExporterStdout:
+		return stdout.New(stdout.WithPrettyPrint())
+	case tracingExporterOTLPGRPC:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(*tracingHeaders)}
+		if *tracingEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(*tracingEndpoint))
+		}
+		if *tracingInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptrace.New(ctx, otlptracegrpc.NewClient(opts...))
+	case tracingExporterOTLPHTTP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(*tracingHeaders)}
+		if *tracingEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(*tracingEndpoint))
+		}
+		if *tracingInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptrace.New(ctx, otlptracehttp.NewClient(opts...))
+	}
+
+	return nil, nil
+}
+
+// newTraceResource describes the server in the exported traces.
+func newTraceResource(ctx context.Context) (*resource.Resource, error) {
+	attributes := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(*tracingServiceName),
+	}
+	for key, value := range *tracingResourceAttributes {
+		attributes = append(attributes, attribute.String(key, value))
+	}
+
+	return resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(attributes...),
+	)
+}
+
+func initTracer() (*sdktrace.TracerProvider, error) {
+	if *tracingSampleRatio < 0 || *tracingSampleRatio > 1 {
+		return nil, fmt.Errorf("the sample ratio must be between 0 and 1, got %v", *tracingSampleRatio)
+	}
+
+	ctx := context.Background()
+
+	res, err := newTraceResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := newTraceExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*tracingSampleRatio))),
+		sdktrace.WithResource(res),
+	}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp, nil
+}
+
+// startStorageSpan starts a child span around a call to the storage provider.
+func startStorageSpan(ctx context.Context, operation string, team tenant, path string) (context.Context, oteltrace.Span) {
+	return tracer.Start(ctx, "storage."+operation,
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(
+			attribute.String("storage.kind", *kind),
+			attribute.String("storage.bucket", team.Bucket),
+			attribute.String("storage.path", path),
+			attribute.String("team.id", team.TeamID),
+		),
+	)
+}
+
+// endStorageSpan ends the span, an item that isn't found is recorded as an
+// attribute instead of an error.
+func endStorageSpan(span oteltrace.Span, err error) {
+	if err == stow.ErrNotFound {
+		span.SetAttributes(attribute.Bool("storage.found", false))
+	} else if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
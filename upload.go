@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+)
+
+var (
+	maxArtifactSize = app.Flag(
+		"max-artifact-size", "The maximum size of a cache artefact, larger uploads are rejected ($TURBO_MAX_ARTIFACT_SIZE).",
+	).Envar("TURBO_MAX_ARTIFACT_SIZE").Default("1GB").Bytes()
+
+	uploadSpoolDir = app.Flag(
+		"upload.spool-dir", "The directory to temporarily store uploads that can't be streamed to the storage provider, defaults to the temp directory ($TURBO_UPLOAD_SPOOL_DIR).",
+	).Envar("TURBO_UPLOAD_SPOOL_DIR").String()
+)
+
+// streamingKinds are the storage providers that accept uploads of unknown
+// size, Amazon S3 uses multipart uploads, Google Cloud Storage uses resumable
+// uploads and Azure Blob Storage uses block uploads.
+var streamingKinds = map[string]bool{
+	"s3":     true,
+	"gcs":    true,
+	"azure":  true,
+	"local":  true,
+	"memory": true,
+}
+
+// artifactSizeLimit returns the maximum size of a cache artefact in bytes, a
+// maximum size of 0 disables the limit.
+func artifactSizeLimit() int64 {
+	if *maxArtifactSize <= 0 {
+		return math.MaxInt64
+	}
+	return int64(*maxArtifactSize)
+}
+
+var errArtifactTooLarge = errors.New("the artifact exceeds the maximum size")
+
+// limitedReader returns errArtifactTooLarge when more than the limit is read.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func newLimitedReader(reader io.Reader, limit int64) *limitedReader {
+	return &limitedReader{reader: reader, remaining: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		l.exceeded = true
+		return 0, errArtifactTooLarge
+	}
+
+	// Read one byte more than allowed, to detect the limit being exceeded
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded = true
+		return n + int(l.remaining), errArtifactTooLarge
+	}
+
+	return n, err
+}
+
+// spooledUpload is an upload that is stored in a temporary file.
+type spooledUpload struct {
+	file *os.File
+	size int64
+}
+
+// spoolUpload copies the body to a temporary file, the contents are also
+// written to the given writer, e.g. to calculate a signature. It's also used
+// for downloads whose signature has to be verified before they are served.
+func spoolUpload(body io.Reader, w io.Writer) (*spooledUpload, error) {
+	file, err := ioutil.TempFile(*uploadSpoolDir, "tapico-upload-")
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &spooledUpload{file: file}
+
+	size, err := io.Copy(io.MultiWriter(file, w), body)
+	if err != nil {
+		upload.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		upload.Close()
+		return nil, err
+	}
+
+	upload.size = size
+	return upload, nil
+}
+
+func (u *spooledUpload) Read(p []byte) (int, error) {
+	return u.file.Read(p)
+}
+
+// Close removes the temporary file.
+func (u *spooledUpload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// writeArtifactTooLarge responds with the error for uploads exceeding the
+// maximum size of a cache artefact.
+func writeArtifactTooLarge(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write([]byte(fmt.Sprintf(`{"error":{"message":"the artifact exceeds the maximum size of %s","code":"payload_too_large"}}`, *maxArtifactSize)))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/units"
+	"github.com/gorilla/mux"
+)
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		limit    int64
+		exceeded bool
+	}{
+		{name: "empty", contents: "", limit: 0},
+		{name: "below the limit", contents: "abc", limit: 4},
+		{name: "at the limit", contents: "abcd", limit: 4},
+		{name: "one byte over the limit", contents: "abcde", limit: 4, exceeded: true},
+		{name: "over a limit of 0", contents: "a", limit: 0, exceeded: true},
+		{name: "far over the limit", contents: strings.Repeat("a", 64*1024), limit: 10, exceeded: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newLimitedReader(strings.NewReader(test.contents), test.limit)
+			read, err := ioutil.ReadAll(reader)
+
+			if test.exceeded {
+				if err != errArtifactTooLarge || !reader.exceeded {
+					t.Fatalf("ReadAll() returned %v with exceeded %v, want errArtifactTooLarge", err, reader.exceeded)
+				}
+				if int64(len(read)) > test.limit {
+					t.Errorf("read %d bytes, want at most the limit of %d bytes", len(read), test.limit)
+				}
+				return
+			}
+
+			if err != nil || reader.exceeded {
+				t.Fatalf("ReadAll() returned %v with exceeded %v, want no error", err, reader.exceeded)
+			}
+			if string(read) != test.contents {
+				t.Errorf("read %q, want %q", read, test.contents)
+			}
+		})
+	}
+}
+
+func TestSpoolUpload(t *testing.T) {
+	previousDir := *uploadSpoolDir
+	t.Cleanup(func() {
+		*uploadSpoolDir = previousDir
+	})
+	*uploadSpoolDir = t.TempDir()
+
+	var written bytes.Buffer
+	upload, err := spoolUpload(strings.NewReader("cache artefact"), &written)
+	if err != nil {
+		t.Fatalf("spoolUpload() failed: %v", err)
+	}
+
+	if upload.size != int64(len("cache artefact")) {
+		t.Errorf("got size %d, want %d", upload.size, len("cache artefact"))
+	}
+	if written.String() != "cache artefact" {
+		t.Errorf("wrote %q to the writer, want the contents", written.String())
+	}
+
+	read, err := ioutil.ReadAll(upload)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if string(read) != "cache artefact" {
+		t.Errorf("read %q, want the contents", read)
+	}
+
+	if err := upload.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if files, _ := os.ReadDir(*uploadSpoolDir); len(files) != 0 {
+		t.Errorf("%d files are left in the spool directory, want none", len(files))
+	}
+
+	// A body exceeding the maximum size doesn't leave its file behind
+	if _, err := spoolUpload(newLimitedReader(strings.NewReader("cache artefact"), 4), ioutil.Discard); err != errArtifactTooLarge {
+		t.Errorf("spoolUpload() returned %v, want errArtifactTooLarge", err)
+	}
+	if files, _ := os.ReadDir(*uploadSpoolDir); len(files) != 0 {
+		t.Errorf("%d files are left in the spool directory, want none", len(files))
+	}
+}
+
+func TestChunkedUploadSizeLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		storageKind string
+		quota       int64
+		contents    string
+		code        int
+	}{
+		{name: "streamed", storageKind: "memory", contents: "abcdefgh", code: http.StatusAccepted},
+		{name: "streamed over the limit", storageKind: "memory", contents: "abcdefghi", code: http.StatusRequestEntityTooLarge},
+		{name: "local over the limit", storageKind: "local", contents: "abcdefghi", code: http.StatusRequestEntityTooLarge},
+		{name: "spooled", storageKind: "memory", quota: 1024, contents: "abcdefgh", code: http.StatusAccepted},
+		{name: "spooled over the limit", storageKind: "memory", quota: 1024, contents: "abcdefghi", code: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTenancy(t, test.storageKind, false, "{{.Hash}}")
+			setupQuota(t, test.quota, quotaPolicyReject)
+
+			previousMaxSize, previousDir := *maxArtifactSize, *uploadSpoolDir
+			t.Cleanup(func() {
+				*maxArtifactSize, *uploadSpoolDir = previousMaxSize, previousDir
+			})
+			*maxArtifactSize = units.Base2Bytes(8)
+			*uploadSpoolDir = t.TempDir()
+
+			// turbo streams the cache artefact without a Content-Length
+			req := httptest.NewRequest(http.MethodPut, "/v8/artifacts/abc?teamId=team_blah", ioutil.NopCloser(strings.NewReader(test.contents)))
+			req.ContentLength = -1
+			req = mux.SetURLVars(req, map[string]string{"artificateId": "abc"})
+			res := httptest.NewRecorder()
+
+			writeCacheItem(res, req)
+
+			if res.Code != test.code {
+				t.Fatalf("upload returned %d, want %d: %s", res.Code, test.code, res.Body)
+			}
+
+			team, err := resolveTenant("team_blah")
+			if err != nil {
+				t.Fatalf("resolveTenant() failed: %v", err)
+			}
+			_, err = readCacheBlob(context.Background(), "abc", team)
+			if stored := err == nil; stored != (test.code == http.StatusAccepted) {
+				t.Errorf("the cache artefact is stored: %v, want it only stored when accepted", stored)
+			}
+			if files, _ := os.ReadDir(*uploadSpoolDir); len(files) != 0 {
+				t.Errorf("%d files are left in the spool directory, want none", len(files))
+			}
+		})
+	}
+}